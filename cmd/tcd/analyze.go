@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal/analyze"
+	"github.com/cilium/test-connection-disruption/internal/events"
+)
+
+var analyzeArgs struct {
+	server         string
+	baseline       string
+	baselineServer string
+}
+
+func init() {
+	commands = append(commands, &command{
+		name:  "analyze",
+		usage: "<client-event-log>",
+		help:  "Reconstruct the timeline of a run from its event logs and compare it to a baseline run",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&analyzeArgs.server, "server", "", "Event log of the server, to correlate connections it saw closing")
+			fs.StringVar(&analyzeArgs.baseline, "baseline", "", "Client event log of a baseline run to compare against. Fails if the run regressed")
+			fs.StringVar(&analyzeArgs.baselineServer, "baseline-server", "", "Event log of the server of the baseline run")
+		},
+		run: runAnalyze,
+	})
+}
+
+// analyzeResult is the result of the analyze command.
+type analyzeResult struct {
+	Analysis *analyze.Analysis `json:"analysis"`
+	// Baseline and Comparisons are only set when comparing to a baseline.
+	Baseline    *analyze.Analysis   `json:"baseline,omitempty"`
+	Comparisons analyze.Comparisons `json:"comparisons,omitempty"`
+}
+
+func (r analyzeResult) String() string {
+	var b strings.Builder
+	b.WriteString(r.Analysis.String())
+	if r.Baseline != nil {
+		b.WriteString("\n\nCompared to baseline:\n")
+		b.WriteString(r.Comparisons.String())
+	}
+	return b.String()
+}
+
+func runAnalyze(env *env, args []string) error {
+	if len(args) != 1 || (analyzeArgs.baselineServer != "" && analyzeArgs.baseline == "") {
+		return errUsage
+	}
+
+	current, err := analyzeRun(args[0], analyzeArgs.server)
+	if err != nil {
+		return err
+	}
+	result := analyzeResult{Analysis: current}
+	env.result = func() any { return result }
+
+	if analyzeArgs.baseline == "" {
+		return nil
+	}
+	result.Baseline, err = analyzeRun(analyzeArgs.baseline, analyzeArgs.baselineServer)
+	if err != nil {
+		return err
+	}
+	result.Comparisons = analyze.Compare(result.Baseline, current)
+	if r := result.Comparisons.Regressions(); len(r) > 0 {
+		return fmt.Errorf("%d regressions compared to baseline", len(r))
+	}
+	return nil
+}
+
+// analyzeRun reads the event logs of a run and analyzes it. The server log is
+// optional.
+func analyzeRun(clientLog, serverLog string) (*analyze.Analysis, error) {
+	client, err := events.ReadFile(clientLog)
+	if err != nil {
+		return nil, err
+	}
+	if len(client) == 0 {
+		return nil, fmt.Errorf("no events in %s", clientLog)
+	}
+	var server []events.Event
+	if serverLog != "" {
+		if server, err = events.ReadFile(serverLog); err != nil {
+			return nil, err
+		}
+	}
+	return analyze.Analyze(client, server), nil
+}
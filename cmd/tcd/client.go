@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+	"github.com/cilium/test-connection-disruption/internal/collector"
+	"github.com/cilium/test-connection-disruption/internal/events"
+	"github.com/cilium/test-connection-disruption/internal/phase"
+	"github.com/cilium/test-connection-disruption/internal/report"
+	"github.com/cilium/test-connection-disruption/internal/scenario"
+)
+
+const maxAttempts = 30
+
+var clientArgs struct {
+	// base holds the flags, which are the defaults for scenario targets.
+	base   scenario.Target
+	config string
+
+	collector string
+	clientID  string
+
+	phases      []string
+	phaseFile   string
+	controlAddr string
+
+	// Thresholds that are disabled with a negative value, as zero is a
+	// meaningful limit.
+	maxLoss        float64
+	maxDialRetries int
+
+	reports        reportFiles
+	timeseriesFile string
+	eventLog       string
+
+	sched internal.Scheduling
+}
+
+func init() {
+	commands = append(commands, &command{
+		name:  "client",
+		usage: "[label=]<addr>...",
+		help:  "Exchange messages with a server and fail when the flow is disrupted",
+		flags: func(fs *flag.FlagSet) {
+			base := &clientArgs.base
+			base.DialAttempts = maxAttempts
+			fs.DurationVar(&base.Interval, "dispatch-interval", 50*time.Millisecond, "TCP packet dispatch interval")
+			fs.DurationVar(&base.Timeout, "timeout", 5*time.Second, "Client exits when no reply is received within this duration")
+			fs.DurationVar(&base.ConnectTimeout, "connect-timeout", 0, "Give up on a connection attempt after this duration (0 waits for the OS to give up)")
+			fs.Float64Var(&base.Rate, "rate", 0, "Send this many messages per second on an absolute timeline in echo and duplex mode instead of pacing them --dispatch-interval apart, measuring round-trip times from when a message was due and reporting missed send slots (0 disables)")
+			fs.StringVar(&base.Arrival, "arrival", internal.ArrivalConstant, fmt.Sprintf("Arrival process of messages with --rate, one of %v", internal.Arrivals))
+			fs.StringVar(&base.Transport, "transport", internal.TransportTCP, fmt.Sprintf("Transport protocol to use, one of %v", internal.Transports))
+			fs.StringVar(&base.Mode, "mode", internal.ModeEcho, fmt.Sprintf("Traffic mode, one of %v. Must match the server's mode", internal.Modes))
+			fs.BoolVar(&base.HalfClose, "half-close", false, "On shutdown, half-close the connection and verify all replies arrived before closing it. In reverse mode, answer the server's half-close instead")
+			fs.BoolVar(&base.OneWayDelay, "one-way-delay", false, "Expect the server to stamp replies with --stamp in echo mode, to measure the delay in each direction and tell which one stalls occurred in")
+			fs.IntVar(&base.ProxyProtocol, "proxy-protocol", 0, "Send a PROXY protocol header of this version (1 or 2) at the start of the connection (0 disables)")
+			fs.DurationVar(&base.ICMPInterval, "icmp-interval", 0, "Send ICMP echo requests to the target host at this interval alongside the TCP flow (0 disables)")
+			fs.DurationVar(&base.Thresholds.MaxGap, "max-gap", 0, "Fail when no message is received for longer than this, a stricter limit than the timeout (0 disables)")
+			fs.DurationVar(&base.Thresholds.MaxStalled, "max-stalled", 0, "Fail when stalls of five dispatch intervals or more add up to more than this (0 disables)")
+			fs.Float64Var(&clientArgs.maxLoss, "max-loss", -1, "Fail when more than this fraction of requests is left without a reply when stopping, in echo mode (negative disables)")
+			fs.DurationVar(&base.Thresholds.MaxP99RTT, "max-p99-rtt", 0, "Fail when the 99th percentile round-trip time exceeds this, in echo mode (0 disables)")
+			fs.Uint64Var(&base.Thresholds.MinOpsPerSecond, "min-ops-per-second", 0, "Fail when fewer messages than this are received in any one second (0 disables)")
+			fs.IntVar(&clientArgs.maxDialRetries, "max-dial-retries", -1, "Fail when connecting takes more retries than this (negative disables)")
+			fs.StringVar(&clientArgs.collector, "collector", "", "Push events and results to the collector at this URL, e.g. http://collector:8080")
+			fs.StringVar(&clientArgs.clientID, "client-id", "", "Identify this client to the collector by this name (defaults to the hostname)")
+			fs.StringSliceVar(&clientArgs.phases, "phases", nil, "Break down results by these phases of the test, starting in the first one. SIGUSR1 advances to the next one")
+			fs.StringVar(&clientArgs.phaseFile, "phase-file", "", "Enter the phase named in this file whenever its content changes")
+			fs.StringVar(&clientArgs.controlAddr, "control-addr", "", "Serve the HTTP control API on this address, to set the phase with POST /phase (empty disables)")
+			fs.StringVar(&clientArgs.timeseriesFile, "timeseries-file", "", "Append a CSV row with the counters of every target and second to this file, TSV if it ends in .tsv")
+			fs.StringVar(&clientArgs.eventLog, "event-log", "", "Write a structured log of connections, stalls, phases and per-second counters to this file, for tcd analyze")
+			clientArgs.reports.register(fs)
+			registerScheduling(fs, &clientArgs.sched)
+			fs.StringVar(&clientArgs.config, "config", "", "Run the targets of this YAML or JSON scenario file, on top of those given as arguments. The other flags are defaults for its targets")
+		},
+		run: runClient,
+	})
+}
+
+func runClient(env *env, args []string) error {
+	base := clientArgs.base
+	if clientArgs.maxLoss >= 0 {
+		base.Thresholds.MaxLoss = &clientArgs.maxLoss
+	}
+	if clientArgs.maxDialRetries >= 0 {
+		base.Thresholds.MaxDialRetries = &clientArgs.maxDialRetries
+	}
+
+	// For backwards compatibility, clamp the interval to a minimum of 10ms to
+	// avoid overloading resource-constrained CI machines where Cilium runs with
+	// monitor aggregation disabled.
+	if base.Interval == 0 {
+		base.Interval = 10 * time.Millisecond
+		env.log.Warn("Zero interval changed for backwards compatibility", "interval", base.Interval)
+	}
+
+	sc := &scenario.Scenario{}
+	if clientArgs.config != "" {
+		var err error
+		if sc, err = scenario.Load(clientArgs.config, base); err != nil {
+			return fmt.Errorf("load scenario: %w", err)
+		}
+	} else if len(args) == 0 {
+		return errUsage
+	}
+	for _, arg := range args {
+		t := base
+		t.Name, t.Addr = parseTarget(arg)
+		sc.Targets = append(sc.Targets, t)
+	}
+	if err := sc.Validate(); err != nil {
+		return err
+	}
+
+	if err := applyScheduling(env.log, clientArgs.sched); err != nil {
+		return err
+	}
+
+	s := &sinks{}
+	if clientArgs.collector != "" {
+		id := clientArgs.clientID
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		s.pusher = collector.NewPusher(clientArgs.collector, id, env.log)
+		defer s.pusher.Close()
+		env.metrics.counter("tcd_client_collector_events_dropped_total", "Events not pushed to the collector as it didn't keep up or failed.", func() float64 { return float64(s.pusher.Dropped()) })
+	}
+	if clientArgs.eventLog != "" {
+		logger, file, err := events.Open(clientArgs.eventLog, events.SourceClient)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		s.events = logger
+	}
+
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	var err error
+	if s.phases, err = trackPhases(ctx, env, s); err != nil {
+		return err
+	}
+	if clientArgs.timeseriesFile != "" {
+		if s.timeseries, err = openTimeseries(clientArgs.timeseriesFile); err != nil {
+			return err
+		}
+	}
+
+	err = runTargets(ctx, env, sc.Targets, s)
+	return errors.Join(err, s.timeseries.Close())
+}
+
+// sinks receive the events and counters of a client run, besides its progress
+// messages. Every sink is optional.
+type sinks struct {
+	// pusher sends events to the collector.
+	pusher *collector.Pusher
+	// phases tracks the phase of the test.
+	phases *phase.Tracker
+	// timeseries records the counters of every second.
+	timeseries *timeseries
+	// events is the event log.
+	events *slog.Logger
+}
+
+// stats records the counters of a target's one second interval.
+func (s *sinks) stats(target string, st client.Stats) {
+	s.timeseries.row(target, st)
+
+	if s.events == nil {
+		return
+	}
+	buckets := make([][2]uint64, len(st.RTTBuckets))
+	for i, b := range st.RTTBuckets {
+		buckets[i] = [2]uint64{uint64(b.Upper), b.Count}
+	}
+	args := []any{"target", target, "tx", st.TX, "rx", st.RX, "bytes", st.Bytes, "in_flight", st.InFlight, "stalled", st.Stalled}
+	if len(buckets) > 0 {
+		args = append(args, "rtt_buckets", buckets)
+	}
+	if st.Phase != "" {
+		args = append(args, "phase", st.Phase)
+	}
+	s.events.Info(events.Interval, args...)
+}
+
+// trackPhases sets up phase tracking if requested, returning nil otherwise.
+// Phase changes are logged and pushed to the collector and event log.
+func trackPhases(ctx context.Context, env *env, s *sinks) (*phase.Tracker, error) {
+	if len(clientArgs.phases) == 0 && clientArgs.phaseFile == "" && clientArgs.controlAddr == "" {
+		return nil, nil
+	}
+
+	initial := "initial"
+	if len(clientArgs.phases) > 0 {
+		initial = clientArgs.phases[0]
+	}
+	phases := phase.NewTracker(initial)
+	phases.Subscribe(func(m phase.Mark) {
+		env.log.Info("Entering phase", "phase", m.Name)
+		if s.pusher != nil {
+			s.pusher.Event(collector.Event{Kind: collector.EventPhase, Phase: m.Name})
+		}
+		if s.events != nil {
+			s.events.Info(events.Phase, "phase", m.Name)
+		}
+	})
+	env.log.Info("Starting in phase", "phase", initial)
+
+	if len(clientArgs.phases) > 1 {
+		go phases.NotifySignal(ctx, syscall.SIGUSR1, clientArgs.phases)
+	}
+	if clientArgs.phaseFile != "" {
+		go phases.WatchFile(ctx, clientArgs.phaseFile, 100*time.Millisecond)
+	}
+	if clientArgs.controlAddr != "" {
+		listener, err := net.Listen("tcp", clientArgs.controlAddr)
+		if err != nil {
+			return nil, fmt.Errorf("listen for control API: %w", err)
+		}
+		srv := &http.Server{Handler: phases.Handler()}
+		context.AfterFunc(ctx, func() { srv.Close() })
+		go srv.Serve(listener)
+		env.log.Info("Serving control API", "addr", listener.Addr())
+	}
+
+	return phases, nil
+}
+
+// parseTarget splits a target argument into its optional label and the
+// address. Unlabeled targets are named after their address by the scenario.
+func parseTarget(arg string) (name, addr string) {
+	if name, addr, ok := strings.Cut(arg, "="); ok {
+		return name, addr
+	}
+	return "", arg
+}
+
+// runTargets runs a client per target until all of them stopped. Targets keep
+// running when others fail. Events, counters and results go to the sinks.
+func runTargets(ctx context.Context, env *env, targets []scenario.Target, s *sinks) error {
+	runs := make([]*targetRun, len(targets))
+	pending := atomic.Int32{}
+	pending.Store(int32(len(targets)))
+	for i, t := range targets {
+		run := &targetRun{target: t, sinks: s}
+		runs[i] = run
+
+		cfg := t.ClientConfig()
+		cfg.Phases = s.phases
+		cfg.Scheduling = clientArgs.sched
+		cfg.Log = env.log.With(internal.LogTarget, t.Name)
+		if s.events != nil {
+			cfg.Events = s.events.With(internal.LogTarget, t.Name)
+		}
+		if len(targets) > 1 {
+			// Report the counters of all targets together.
+			cfg.QuietStats = true
+		} else {
+			cfg.OnStats = func(st client.Stats) { s.stats(t.Name, st) }
+		}
+		cfg.OnStall = run.stall
+		// Signal readiness once traffic flows to all targets.
+		cfg.OnReady = func() {
+			run.event(collector.EventReady, "")
+			if pending.Add(-1) == 0 {
+				ready("/tmp/client-ready")
+			}
+		}
+
+		run.client = client.New(cfg)
+
+		labels := []string{"target", t.Name}
+		env.metrics.counter("tcd_client_messages_sent_total", "Messages sent to the server.", func() float64 { return float64(run.client.Result().Sent) }, labels...)
+		env.metrics.counter("tcd_client_messages_received_total", "Valid messages received from the server.", func() float64 { return float64(run.client.Result().Received) }, labels...)
+		env.metrics.gauge("tcd_client_max_gap_seconds", "Longest time without receiving a message.", func() float64 { return run.client.Result().MaxGap.Seconds() }, labels...)
+	}
+
+	flows := func() report.Flows {
+		flows := make(report.Flows, len(runs))
+		for i, run := range runs {
+			flows[i] = run.flow()
+		}
+		return flows
+	}
+	env.result = func() any { return flows() }
+
+	if len(runs) > 1 {
+		logCtx, stopLogger := context.WithCancel(context.Background())
+		defer stopLogger()
+		go logStats(logCtx, env.log, runs, s)
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if s.pusher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.pusher.Results(ctx, flows()); err != nil {
+			env.log.Error("Error pushing results to collector", "error", err)
+		}
+		if dropped := s.pusher.Dropped(); dropped > 0 {
+			env.log.Warn("Events dropped for collector", "dropped", dropped)
+		}
+	}
+
+	rep := report.Aggregate(map[string]report.Flows{"": flows()}, 0)
+	return errors.Join(runsError(runs), clientArgs.reports.write(rep, "tcd client"))
+}
+
+// runsError returns the error of a single target, or summarizes those of
+// many.
+func runsError(runs []*targetRun) error {
+	if len(runs) == 1 {
+		return runs[0].err
+	}
+
+	var errs []error
+	for _, run := range runs {
+		if run.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", run.target.Name, run.err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d targets failed:\n%w", len(errs), len(runs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// targetRun is the client of a single target.
+type targetRun struct {
+	target scenario.Target
+	client *client.Client
+	// err is the error the client failed with, valid once run returned.
+	err   error
+	sinks *sinks
+}
+
+// event pushes an event to the collector, if any.
+func (r *targetRun) event(kind, msg string) {
+	if r.sinks.pusher == nil {
+		return
+	}
+	ev := collector.Event{Target: r.target.Name, Kind: kind, Message: msg}
+	if r.sinks.phases != nil {
+		ev.Phase = r.sinks.phases.Current()
+	}
+	r.sinks.pusher.Event(ev)
+}
+
+// stall pushes a stall to the collector, if any.
+func (r *targetRun) stall(st client.Stall) {
+	if r.sinks.pusher == nil {
+		return
+	}
+	msg := fmt.Sprintf("stalled for %s", st.Duration.Round(time.Millisecond))
+	if st.Direction != "" {
+		msg += " " + st.Direction
+	}
+	r.sinks.pusher.Event(collector.Event{Target: r.target.Name, Phase: st.Phase, Kind: collector.EventStall, Message: msg, DurationSeconds: st.Duration.Seconds()})
+}
+
+// logStats prints the counters of all targets every second, broken down per
+// target, and records them in the sinks.
+func logStats(ctx context.Context, log *slog.Logger, runs []*targetRun, s *sinks) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var total client.Stats
+		targets := make([]any, len(runs))
+		for i, run := range runs {
+			st := run.client.TakeStats()
+			s.stats(run.target.Name, st)
+			total.TX, total.RX, total.Bytes = total.TX+st.TX, total.RX+st.RX, total.Bytes+st.Bytes
+			target := []any{"tx", st.TX, "rx", st.RX}
+			if st.Pacing.Count > 0 {
+				target = append(target, "send_rate", math.Round(st.Pacing.Rate()*10)/10, "jitter", st.Pacing.Jitter())
+			}
+			targets[i] = slog.Group(run.target.Name, target...)
+		}
+		attrs := []any{"tx", total.TX, "rx", total.RX, "bytes", total.Bytes}
+		if s.phases != nil {
+			attrs = append(attrs, "phase", s.phases.Current())
+		}
+		log.Info("Operations per second", append(attrs, slog.Group("targets", targets...))...)
+	}
+}
+
+func (r *targetRun) run(ctx context.Context) {
+	r.err = r.client.Run(ctx)
+
+	if r.sinks.events != nil {
+		res := r.client.Result()
+		args := []any{internal.LogTarget, r.target.Name, "sent", res.Sent, "received", res.Received, "max_gap_seconds", res.MaxGap.Seconds()}
+		if r.err != nil {
+			args = append(args, "error", r.err.Error(), "failure", client.KindOf(r.err).String())
+		}
+		r.sinks.events.Info(events.Done, args...)
+	}
+
+	if r.err != nil {
+		r.event(collector.EventFailed, r.err.Error())
+	} else {
+		r.event(collector.EventDone, "")
+	}
+}
+
+func (r *targetRun) flow() report.Flow {
+	res := r.client.Result()
+	s := report.Flow{
+		Name:            r.target.Name,
+		Addr:            r.target.Addr,
+		OK:              r.err == nil,
+		Sent:            res.Sent,
+		Received:        res.Received,
+		MaxGapSeconds:   res.MaxGap.Seconds(),
+		DurationSeconds: res.Duration.Seconds(),
+		Stalls:          res.Stalls,
+		StalledSeconds:  res.Stalled.Seconds(),
+		MissedSlots:     res.MissedSlots,
+		DroppedSlots:    res.DroppedSlots,
+
+		SendRate:           res.Pacing.Rate(),
+		TargetRate:         res.TargetRate,
+		SendJitterSeconds:  res.Pacing.Jitter().Seconds(),
+		ClientBoundSeconds: res.ClientBound,
+
+		UpstreamDelaySeconds:   res.Upstream.Avg().Seconds(),
+		DownstreamDelaySeconds: res.Downstream.Avg().Seconds(),
+		ClockOffsetSeconds:     res.ClockOffset.Seconds(),
+
+		Directions: []report.Direction{
+			direction(internal.DirectionClientToServer, res.ClientToServer),
+			direction(internal.DirectionServerToClient, res.ServerToClient),
+		},
+	}
+	for _, st := range res.StallList {
+		s.StallList = append(s.StallList, report.Stall{
+			Start: st.Start, DurationSeconds: st.Duration.Seconds(), Phase: st.Phase, Direction: st.Direction,
+			ICMPSent: st.ICMPSent, ICMPReceived: st.ICMPReceived,
+		})
+	}
+	for _, e := range r.target.Thresholds.Evaluate(res) {
+		s.Thresholds = append(s.Thresholds, report.Threshold{Name: e.Threshold, Limit: e.Limit, Value: e.Value, OK: e.Err == nil})
+	}
+	if r.err != nil {
+		s.Error = r.err.Error()
+		s.Failure = client.KindOf(r.err).String()
+	}
+	if te := (*client.ThresholdError)(nil); errors.As(r.err, &te) {
+		s.Threshold = te.Threshold
+	}
+	for _, p := range res.Phases {
+		rp := report.Phase{
+			Name:            p.Name,
+			DurationSeconds: p.Duration.Seconds(),
+			Sent:            p.Sent,
+			Received:        p.Received,
+			MaxGapSeconds:   p.MaxGap.Seconds(),
+			Stalls:          p.Stalls,
+			StalledSeconds:  p.Stalled.Seconds(),
+			RTTMinSeconds:   p.RTT.Min.Seconds(),
+			RTTAvgSeconds:   p.RTT.Avg().Seconds(),
+			RTTMaxSeconds:   p.RTT.Max.Seconds(),
+		}
+		if p.Duration > 0 {
+			rp.OpsPerSecond = float64(p.Received) / p.Duration.Seconds()
+		}
+		s.Phases = append(s.Phases, rp)
+	}
+	return s
+}
+
+// direction converts the result of one direction of a flow for the report.
+func direction(name string, d client.DirectionResult) report.Direction {
+	return report.Direction{Direction: name, Sent: d.Sent, Received: d.Received, MaxGapSeconds: d.MaxGap.Seconds()}
+}
+
+// ready signals readiness to probes by creating the given file.
+func ready(path string) {
+	file, err := os.Create(path)
+	internal.ErrExit("create ready file", err)
+	internal.ErrExit("close ready file", file.Close())
+}
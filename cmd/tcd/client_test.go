@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	for arg, want := range map[string][2]string{
+		"10.0.0.1:8000":           {"", "10.0.0.1:8000"},
+		"pod=10.0.0.1:8000":       {"pod", "10.0.0.1:8000"},
+		"svc=[fd00::1]:8000":      {"svc", "[fd00::1]:8000"},
+		"external=example.com:80": {"external", "example.com:80"},
+	} {
+		name, addr := parseTarget(arg)
+		if name != want[0] || addr != want[1] {
+			t.Errorf("%s: expected %q, %q, got %q, %q", arg, want[0], want[1], name, addr)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal/collector"
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+var collectorArgs struct {
+	expect  int
+	reports reportFiles
+}
+
+func init() {
+	commands = append(commands, &command{
+		name:  "collector",
+		usage: "<port>",
+		help:  "Aggregate the events and results pushed by many clients into one verdict",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&collectorArgs.expect, "expect-clients", 0, "Number of clients expected to push results. The verdict is incomplete until all did (0 disables)")
+			collectorArgs.reports.register(fs)
+		},
+		run: runCollector,
+	})
+}
+
+func runCollector(env *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	addr := args[0]
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+
+	c := collector.New(collectorArgs.expect, env.log)
+	env.result = func() any { return c.Report() }
+	env.metrics.gauge("tcd_collector_clients", "Clients that pushed results.", func() float64 { return float64(c.Report().Clients) })
+	env.metrics.gauge("tcd_collector_flows_failed", "Flows reported as failed.", func() float64 { return float64(c.Report().FailedFlows) })
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	srv := &http.Server{Handler: c.Handler()}
+	context.AfterFunc(ctx, func() { srv.Close() })
+
+	env.log.Info("Collecting results", "addr", listener.Addr())
+	ready("/tmp/collector-ready")
+
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	rep := c.Report()
+	return errors.Join(verdictError(rep), collectorArgs.reports.write(rep, "tcd collector"))
+}
+
+// verdictError returns an error unless the report passes.
+func verdictError(rep report.Report) error {
+	switch rep.Verdict {
+	case report.VerdictFail:
+		return fmt.Errorf("%d of %d flows failed", rep.FailedFlows, rep.Flows)
+	case report.VerdictIncomplete:
+		return fmt.Errorf("only %d of %d clients reported", rep.Clients, rep.ExpectedClients)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+)
+
+// Output formats of the result printed when a command exits.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+var formats = []string{formatText, formatJSON}
+
+// sharedFlags are accepted by every command.
+type sharedFlags struct {
+	output      string
+	resultFile  string
+	metricsAddr string
+	logFile     string
+	logLevel    string
+	logFormat   string
+}
+
+func (f *sharedFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.output, "output", formatText, fmt.Sprintf("Format of the result printed on exit, one of %v", formats))
+	fs.StringVar(&f.resultFile, "result-file", "", "Write the result to this file instead of stdout")
+	fs.StringVar(&f.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this address (empty disables)")
+	fs.StringVar(&f.logFile, "log-file", "", "Write progress messages to this file instead of stdout, or stderr with a JSON result on stdout")
+	fs.StringVar(&f.logLevel, "log-level", "info", "Lowest level of progress messages logged, one of debug, info, warn or error. Per-second stats are logged at info")
+	fs.StringVar(&f.logFormat, "log-format", internal.LogFormatText, fmt.Sprintf("Format of progress messages, one of %v", internal.LogFormats))
+}
+
+// env is the environment a command runs in, as set up by the shared flags.
+type env struct {
+	command string
+	format  string
+
+	// log receives progress messages.
+	log     *slog.Logger
+	logFile *os.File
+	// resultOut receives the result on exit.
+	resultOut  io.Writer
+	resultFile *os.File
+
+	metrics       *metrics
+	metricsServer *http.Server
+
+	// result returns the command's result for printing on exit, if set.
+	result func() any
+}
+
+func (f *sharedFlags) env(command string) (*env, error) {
+	if !slices.Contains(formats, f.output) {
+		return nil, fmt.Errorf("unsupported output format %q", f.output)
+	}
+
+	level, err := internal.ParseLogLevel(f.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(internal.LogFormats, f.logFormat) {
+		return nil, fmt.Errorf("unsupported log format %q", f.logFormat)
+	}
+
+	e := &env{command: command, format: f.output, metrics: &metrics{}, resultOut: os.Stdout}
+
+	if f.resultFile != "" {
+		file, err := os.Create(f.resultFile)
+		if err != nil {
+			return nil, fmt.Errorf("create result file: %w", err)
+		}
+		e.resultOut, e.resultFile = file, file
+	}
+
+	var out io.Writer = os.Stdout
+	switch {
+	case f.logFile != "":
+		file, err := os.Create(f.logFile)
+		if err != nil {
+			e.close()
+			return nil, fmt.Errorf("create log file: %w", err)
+		}
+		out, e.logFile = file, file
+	case f.output == formatJSON && f.resultFile == "":
+		// Keep stdout for the result, so it can be parsed.
+		out = os.Stderr
+	}
+	if e.log, err = internal.NewLogger(out, f.logFormat, level); err != nil {
+		return nil, err
+	}
+
+	if f.metricsAddr != "" {
+		e.metricsServer = &http.Server{Addr: f.metricsAddr, Handler: e.metrics}
+		go func() {
+			if err := e.metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				e.log.Error("Error serving metrics", "error", err)
+			}
+		}()
+	}
+
+	return e, nil
+}
+
+func (e *env) close() {
+	if e.metricsServer != nil {
+		e.metricsServer.Close()
+	}
+	if e.logFile != nil {
+		e.logFile.Close()
+	}
+	if e.resultFile != nil {
+		e.resultFile.Close()
+	}
+}
+
+// finish prints the command's result and the error it failed with, if any, in
+// the requested format.
+func (e *env) finish(err error) {
+	if e.result == nil {
+		return
+	}
+	result := e.result()
+
+	if e.format == formatText {
+		fmt.Fprintln(e.resultOut, result)
+		return
+	}
+
+	report := struct {
+		Command string `json:"command"`
+		OK      bool   `json:"ok"`
+		Error   string `json:"error,omitempty"`
+		// Failure is the kind of the error, see [client.Kind].
+		Failure  string `json:"failure,omitempty"`
+		ExitCode int    `json:"exit_code"`
+		Result   any    `json:"result"`
+	}{Command: e.command, OK: err == nil, Result: result}
+	if err != nil {
+		report.Error = err.Error()
+		report.Failure = client.KindOf(err).String()
+		report.ExitCode = exitCode(err)
+	}
+	enc := json.NewEncoder(e.resultOut)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// metric is a single Prometheus metric whose value is read on every scrape.
+type metric struct {
+	name, help, kind string
+	// labels are formatted as {key="value",...} or empty.
+	labels string
+	value  func() float64
+}
+
+// metrics serves registered metrics in the Prometheus text format.
+type metrics struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// counter registers a counter. labels are pairs of label names and values.
+func (m *metrics) counter(name, help string, value func() float64, labels ...string) {
+	m.add(metric{name, help, "counter", formatLabels(labels), value})
+}
+
+// gauge registers a gauge. labels are pairs of label names and values.
+func (m *metrics) gauge(name, help string, value func() float64, labels ...string) {
+	m.add(metric{name, help, "gauge", formatLabels(labels), value})
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metrics) add(mt metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = append(m.metrics, mt)
+	// Series of the same metric must be listed together.
+	slices.SortStableFunc(m.metrics, func(a, b metric) int { return strings.Compare(a.name, b.name) })
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for i, metric := range m.metrics {
+		if i == 0 || m.metrics[i-1].name != metric.name {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		}
+		fmt.Fprintf(w, "%s%s %g\n", metric.name, metric.labels, metric.value())
+	}
+}
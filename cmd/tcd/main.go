@@ -0,0 +1,126 @@
+// Command tcd is the connection disruption test tool. It bundles the server,
+// client and proxy as subcommands of a single binary, which also answers to
+// the tcd-server, tcd-client and tcd-proxy names of the former binaries when
+// invoked through a link with that name.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+)
+
+// command is a subcommand of tcd.
+type command struct {
+	name  string
+	usage string
+	help  string
+	// flags registers the command's own flags. The shared flags are added to
+	// the flag set on top of them.
+	flags func(fs *flag.FlagSet)
+	run   func(env *env, args []string) error
+	// legacyFlags makes the command accept single-dash long flags, for
+	// commands that used to parse them with the standard library.
+	legacyFlags bool
+}
+
+var commands []*command
+
+func main() {
+	name, args := filepath.Base(os.Args[0]), os.Args[1:]
+	cmd := lookup(strings.TrimPrefix(name, "tcd-"))
+	if cmd == nil {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+			usage()
+			os.Exit(1)
+		}
+		if cmd = lookup(args[0]); cmd == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+			usage()
+			os.Exit(1)
+		}
+		name, args = "tcd "+cmd.name, args[1:]
+	}
+
+	os.Exit(execute(cmd, name, args))
+}
+
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: tcd <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.help)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "tcd <command> --help" for the flags of a command.`)
+}
+
+// execute parses the flags of cmd, runs it and returns the exit status.
+func execute(cmd *command, name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SortFlags = false
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] %s\n", name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	var shared sharedFlags
+	shared.register(fs)
+
+	if cmd.legacyFlags {
+		args = legacyFlags(args)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		return 1
+	}
+
+	env, err := shared.env(cmd.name)
+	internal.ErrExit("set up", err)
+	defer env.close()
+
+	err = cmd.run(env, fs.Args())
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		return 1
+	}
+	env.finish(err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// exitCode returns the exit status for a command that failed with err. Client
+// failures map to a distinct status per kind, see [client.Kind.ExitCode].
+// When several clients failed, the most severe failure determines it.
+func exitCode(err error) int {
+	return client.KindOf(err).ExitCode()
+}
+
+// errUsage is returned by commands invoked with invalid arguments.
+var errUsage = errors.New("invalid usage")
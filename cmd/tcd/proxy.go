@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal/proxy"
+)
+
+var proxyArgs struct {
+	cfg     proxy.Config
+	apiAddr string
+
+	fault         string
+	faultStart    time.Duration
+	faultDuration time.Duration
+	faultDelay    time.Duration
+}
+
+func init() {
+	commands = append(commands, &command{
+		name:  "proxy",
+		usage: "<listen-port> <target-addr>",
+		help:  "Forward traffic between client and server and inject faults",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&proxyArgs.cfg.Protocol, "protocol", "tcp", fmt.Sprintf("Protocol to proxy, one of %v", proxy.Protocols))
+			fs.StringVar(&proxyArgs.apiAddr, "api-addr", "", "Serve the HTTP fault injection API on this address (empty disables)")
+			fs.StringVar(&proxyArgs.fault, "fault", "", fmt.Sprintf("Inject this fault on a schedule, one of %v", proxy.FaultKinds))
+			fs.DurationVar(&proxyArgs.faultStart, "fault-start", 0, "Inject --fault this long after startup")
+			fs.DurationVar(&proxyArgs.faultDuration, "fault-duration", 0, "Keep --fault active for this long (0 keeps it active until cleared)")
+			fs.DurationVar(&proxyArgs.faultDelay, "fault-delay", 0, "Delay for the delay fault")
+		},
+		run: runProxy,
+	})
+}
+
+func runProxy(env *env, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	cfg := proxyArgs.cfg
+	cfg.Target = args[1]
+	cfg.Log = env.log
+
+	addr := args[0]
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+
+	p, err := proxy.Listen(cfg, addr)
+	if err != nil {
+		return err
+	}
+	env.metrics.gauge("tcd_proxy_connections", "Open connections on both sides of the proxy.", func() float64 { return float64(p.Connections()) })
+
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	if proxyArgs.fault != "" {
+		go p.Schedule(ctx, proxyArgs.fault, proxyArgs.faultStart, proxyArgs.faultDuration, proxyArgs.faultDelay)
+	}
+
+	if proxyArgs.apiAddr != "" {
+		srv := &http.Server{Addr: proxyArgs.apiAddr, Handler: p.Handler()}
+		context.AfterFunc(ctx, func() { srv.Close() })
+		go func() {
+			env.log.Info("Serving fault injection API", "addr", proxyArgs.apiAddr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				env.log.Error("Error serving API", "error", err)
+			}
+		}()
+	}
+
+	return p.Serve(ctx)
+}
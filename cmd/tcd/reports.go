@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+// reportFiles are the files reports are written to when a command exits, for
+// CI systems and reviewers.
+type reportFiles struct {
+	junit    string
+	markdown string
+}
+
+func (f *reportFiles) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.junit, "junit-file", "", "Write a JUnit XML report with a test case per flow and threshold to this file on exit")
+	fs.StringVar(&f.markdown, "markdown-file", "", "Write a Markdown summary to this file on exit")
+}
+
+// write writes the report to the requested files. name names the JUnit test
+// suite of flows that don't belong to a client.
+func (f *reportFiles) write(rep report.Report, name string) error {
+	var errs []error
+	if f.junit != "" {
+		errs = append(errs, writeFile(f.junit, func(w io.Writer) error { return rep.WriteJUnit(w, name) }))
+	}
+	if f.markdown != "" {
+		errs = append(errs, writeFile(f.markdown, rep.WriteMarkdown))
+	}
+	return errors.Join(errs...)
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("write report %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// registerScheduling registers the flags configuring the scheduling of the
+// process and of its threads pacing messages.
+func registerScheduling(fs *flag.FlagSet, s *internal.Scheduling) {
+	fs.IntVar(&s.Nice, "nice", 19, "Niceness of the process, from -20 (highest priority) to 19 (lowest)")
+	fs.StringVar(&s.Policy, "sched-policy", "", fmt.Sprintf("Scheduling policy of the threads pacing messages, one of %v (empty keeps the process' policy)", internal.SchedPolicies))
+	fs.IntVar(&s.Priority, "sched-priority", 0, "Realtime priority of the threads pacing messages with the fifo and rr policies, from 1 to 99")
+	fs.IntSliceVar(&s.CPUs, "pacing-cpus", nil, "Pin the threads pacing messages to these CPUs, e.g. 2,3 (empty keeps the process' affinity)")
+	fs.DurationVar(&s.TimerSlack, "timer-slack", 0, "Let the kernel wake up the threads pacing messages up to this late to coalesce wakeups (0 keeps the default of 50µs)")
+}
+
+// applyScheduling sets the scheduling of the process and logs the effective
+// settings, along with those requested for the threads pacing messages.
+func applyScheduling(log *slog.Logger, s internal.Scheduling) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := s.ApplyProcess(); err != nil {
+		return fmt.Errorf("apply scheduling: %w", err)
+	}
+
+	pacing := []any{}
+	if s.Policy != "" {
+		pacing = append(pacing, "policy", s.Policy)
+	}
+	if s.Priority != 0 {
+		pacing = append(pacing, "priority", s.Priority)
+	}
+	if len(s.CPUs) > 0 {
+		pacing = append(pacing, "cpus", s.CPUs)
+	}
+	if s.TimerSlack > 0 {
+		pacing = append(pacing, "timer_slack", s.TimerSlack)
+	}
+	log.Info("Scheduling", append(internal.SchedAttrs(), slog.Group("pacing", pacing...))...)
+	return nil
+}
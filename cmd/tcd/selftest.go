@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+	"github.com/cilium/test-connection-disruption/internal/proxy"
+	"github.com/cilium/test-connection-disruption/internal/server"
+)
+
+var selftestDuration time.Duration
+
+func init() {
+	commands = append(commands, &command{
+		name: "selftest",
+		help: "Run client, server and proxy against each other on loopback",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&selftestDuration, "duration", 2*time.Second, "Duration of every check")
+		},
+		run: runSelftest,
+	})
+}
+
+// check is a single selftest check.
+type check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// selftestSummary is the result of the selftest command.
+type selftestSummary []check
+
+func (s selftestSummary) String() string {
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		status := "ok"
+		if !c.OK {
+			status = "FAIL: " + c.Error
+		}
+		fmt.Fprintf(&b, "%-20s %s", c.Name, status)
+	}
+	return b.String()
+}
+
+func runSelftest(env *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	var summary selftestSummary
+	env.result = func() any { return summary }
+
+	run := func(name string, f func(log *slog.Logger) error) {
+		env.log.Info("Running check", "check", name)
+		err := f(env.log.With("check", name))
+		c := check{Name: name, OK: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		summary = append(summary, c)
+	}
+
+	for _, mode := range internal.Modes {
+		run(mode, func(log *slog.Logger) error { return selftestMode(log, mode) })
+	}
+	for _, f := range selftestFaults {
+		run(f.name, func(log *slog.Logger) error { return selftestFault(log, f.fault, f.duration, f.kind) })
+	}
+
+	for _, c := range summary {
+		if !c.OK {
+			return errors.New("selftest failed")
+		}
+	}
+	return nil
+}
+
+// selftestServer runs a server on loopback until ctx is cancelled.
+func selftestServer(ctx context.Context, log *slog.Logger, mode string) (string, <-chan error, error) {
+	srv, err := server.Listen(server.Config{
+		Transport: internal.TransportTCP,
+		Mode:      mode,
+		Interval:  10 * time.Millisecond,
+		Timeout:   time.Second,
+		HalfClose: true,
+		Log:       log.With("component", "server"),
+	}, "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+	return srv.Addr().String(), done, nil
+}
+
+// selftestMode checks that client and server exchange messages in the given
+// mode, and that none get lost in echo mode.
+func selftestMode(log *slog.Logger, mode string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, done, err := selftestServer(ctx, log, mode)
+	if err != nil {
+		return err
+	}
+
+	c := client.New(client.Config{
+		Addr:      addr,
+		Transport: internal.TransportTCP,
+		Mode:      mode,
+		Interval:  10 * time.Millisecond,
+		Timeout:   time.Second,
+		HalfClose: true,
+		Log:       log.With("component", "client"),
+	})
+	runCtx, stop := context.WithTimeout(ctx, selftestDuration)
+	defer stop()
+	if err := c.Run(runCtx); err != nil {
+		return err
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		return err
+	}
+
+	res := c.Result()
+	if res.Received == 0 {
+		return errors.New("no messages received")
+	}
+	if mode == internal.ModeEcho && res.Sent != res.Received {
+		return fmt.Errorf("sent %d messages, but received %d", res.Sent, res.Received)
+	}
+	return nil
+}
+
+// selftestTimeout is the client timeout of the checks injecting faults.
+const selftestTimeout = 500 * time.Millisecond
+
+// selftestFaults are the checks injecting a fault for the given duration, zero
+// meaning until the check ends, and the kind of error the client must fail
+// with, see [selftestFault].
+var selftestFaults = []struct {
+	name, fault string
+	duration    time.Duration
+	kind        client.Kind
+}{
+	{"detect-blackhole", "blackhole", 0, client.KindTimeout},
+	{"detect-corrupt", "corrupt", 0, client.KindCorrupt},
+	{"detect-reset", "reset", 0, client.KindClosed},
+	// A pause shorter than the timeout stalls the client without failing it.
+	{"tolerate-pause", "pause", selftestTimeout / 2, client.KindUnknown},
+}
+
+// selftestFault checks that the client detects a fault injected by the proxy
+// halfway through the check, failing with an error of the given kind. With
+// KindUnknown, the client must record a stall without failing instead.
+func selftestFault(log *slog.Logger, fault string, duration time.Duration, kind client.Kind) error {
+	const timeout = selftestTimeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, serverDone, err := selftestServer(ctx, log, internal.ModeEcho)
+	if err != nil {
+		return err
+	}
+	// Wait for the server and the proxy to shut down, after the check's
+	// connections are gone.
+	defer func() {
+		cancel()
+		<-serverDone
+	}()
+
+	p, err := proxy.Listen(proxy.Config{Protocol: "tcp", Target: addr, Log: log.With("component", "proxy")}, "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	proxyDone := make(chan error, 1)
+	go func() { proxyDone <- p.Serve(ctx) }()
+	defer func() {
+		cancel()
+		<-proxyDone
+	}()
+
+	c := client.New(client.Config{
+		Addr:      p.Addr().String(),
+		Transport: internal.TransportTCP,
+		Mode:      internal.ModeEcho,
+		Interval:  10 * time.Millisecond,
+		Timeout:   timeout,
+		Log:       log.With("component", "client"),
+		OnReady: func() {
+			go p.Schedule(ctx, fault, selftestDuration/2, duration, 0)
+		},
+	})
+	runCtx, stop := context.WithTimeout(ctx, selftestDuration+timeout)
+	defer stop()
+
+	err = c.Run(runCtx)
+	if kind == client.KindUnknown {
+		if err != nil {
+			return fmt.Errorf("expected client to ride out the %s, got: %w", fault, err)
+		}
+		if c.Result().Stalls == 0 {
+			return fmt.Errorf("expected client to record a stall during the %s", fault)
+		}
+		return nil
+	}
+	if got := client.KindOf(err); err == nil || got != kind {
+		return fmt.Errorf("expected a %s error (exit status %d), got %v: %v", kind, kind.ExitCode(), got, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal/testutil"
+)
+
+func TestSelftestFaults(t *testing.T) {
+	selftestDuration = time.Second
+	for _, f := range selftestFaults {
+		t.Run(f.name, func(t *testing.T) {
+			if err := selftestFault(testutil.Logger(t), f.fault, f.duration, f.kind); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
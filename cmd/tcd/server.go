@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/events"
+	"github.com/cilium/test-connection-disruption/internal/server"
+)
+
+var (
+	serverCfg      server.Config
+	serverEventLog string
+)
+
+func init() {
+	commands = append(commands, &command{
+		name:  "server",
+		usage: "<port>",
+		help:  "Serve clients, echoing their messages or driving the flow itself",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&serverCfg.Transport, "transport", internal.TransportTCP, fmt.Sprintf("Transport protocol to use, one of %v", internal.Transports))
+			fs.StringVar(&serverCfg.Mode, "mode", internal.ModeEcho, fmt.Sprintf("Traffic mode, one of %v. Must match the client's mode", internal.Modes))
+			fs.DurationVar(&serverCfg.Interval, "dispatch-interval", 50*time.Millisecond, "Message dispatch interval in reverse and duplex mode")
+			fs.DurationVar(&serverCfg.Timeout, "timeout", 5*time.Second, "Connections are closed when no message is received within this duration in reverse and duplex mode")
+			fs.DurationVar(&serverCfg.StallThreshold, "stall-threshold", time.Second, "Report gaps between two messages received in reverse and duplex mode longer than this")
+			fs.BoolVar(&serverCfg.HalfClose, "half-close", false, "On shutdown in reverse and duplex mode, half-close connections and verify all messages arrived before closing them")
+			fs.StringVar(&serverEventLog, "event-log", "", "Write a structured log of accepted and closed connections to this file, for tcd analyze")
+			fs.BoolVar(&serverCfg.ProxyProtocol, "proxy-protocol", false, "Expect a PROXY protocol v1 or v2 header within the timeout on every connection and use the original client address it conveys")
+			fs.BoolVar(&serverCfg.Stamp, "stamp", false, "Stamp replies with the times a message was received and sent back in echo mode, for clients measuring one-way delays with --one-way-delay")
+			registerScheduling(fs, &serverCfg.Scheduling)
+		},
+		run:         runServer,
+		legacyFlags: true,
+	})
+}
+
+func runServer(env *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	cfg := serverCfg
+	cfg.Log = env.log
+	if serverEventLog != "" {
+		logger, file, err := events.Open(serverEventLog, events.SourceServer)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		cfg.Events = logger
+	}
+
+	if err := applyScheduling(env.log, cfg.Scheduling); err != nil {
+		return err
+	}
+
+	// Accept a full address on top of the port the server always took.
+	addr := args[0]
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+
+	srv, err := server.Listen(cfg, addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	env.result = func() any { return serverSummary(srv.Result()) }
+	env.metrics.counter("tcd_server_connections_total", "Connections accepted.", func() float64 { return float64(srv.Result().Connections) })
+	env.metrics.counter("tcd_server_connections_failed_total", "Connections that ended with an error.", func() float64 { return float64(srv.Result().Failed) })
+
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	ready("/tmp/server-ready")
+
+	if err := srv.Serve(ctx); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
+
+// serverSummary is the result of the server command.
+type serverSummary struct {
+	Connections uint64 `json:"connections"`
+	Failed      uint64 `json:"failed"`
+}
+
+func (s serverSummary) String() string {
+	return fmt.Sprintf("Served %d connections, %d of them failed", s.Connections, s.Failed)
+}
+
+// legacyFlags rewrites single-dash long flags, as accepted by the standalone
+// server binary, to the double-dash form.
+func legacyFlags(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(out[i:], args[i:])
+			break
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			arg = "-" + arg
+		}
+		out[i] = arg
+	}
+	return out
+}
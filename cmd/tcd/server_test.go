@@ -0,0 +1,14 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLegacyFlags(t *testing.T) {
+	got := legacyFlags([]string{"-mode=reverse", "-timeout", "1s", "--half-close", "-h", "8000", "--", "-x"})
+	want := []string{"--mode=reverse", "--timeout", "1s", "--half-close", "-h", "8000", "--", "-x"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal/client"
+)
+
+// timeseriesHeader names the columns of the time series.
+var timeseriesHeader = []string{
+	"timestamp", "target", "phase", "tx", "rx", "bytes", "in_flight",
+	"rtt_min_seconds", "rtt_avg_seconds", "rtt_max_seconds", "stalled",
+	"missed_slots", "send_rate", "send_jitter_seconds",
+	"delay_up_avg_seconds", "delay_down_avg_seconds",
+}
+
+// timeseries appends a row per target and one second interval to a CSV file,
+// or a TSV file if its name ends in .tsv, for plotting runs. A nil timeseries
+// discards all rows.
+type timeseries struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+// openTimeseries opens the file at path for appending, writing the header if
+// the file is empty. Refuses to append to a file with other columns, such as
+// one written by an older version.
+func openTimeseries(path string) (*timeseries, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open time series: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open time series: %w", err)
+	}
+
+	ts := &timeseries{file: file, w: csv.NewWriter(file)}
+	if filepath.Ext(path) == ".tsv" {
+		ts.w.Comma = '\t'
+	}
+	if info.Size() == 0 {
+		ts.w.Write(timeseriesHeader)
+		return ts, nil
+	}
+
+	r := csv.NewReader(file)
+	r.Comma = ts.w.Comma
+	header, err := r.Read()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read time series header: %w", err)
+	}
+	if !slices.Equal(header, timeseriesHeader) {
+		file.Close()
+		return nil, fmt.Errorf("time series %s has columns %v, expected %v", path, header, timeseriesHeader)
+	}
+	return ts, nil
+}
+
+// row records the counters of a target's interval ending now.
+func (ts *timeseries) row(target string, st client.Stats) {
+	if ts == nil {
+		return
+	}
+
+	rtt := func(d time.Duration) string {
+		if st.RTT.Count == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	delay := func(s client.RTTStats) string {
+		if s.Count == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(s.Avg().Seconds(), 'f', -1, 64)
+	}
+	pacing := func(v float64) string {
+		if st.Pacing.Count == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	stalled := "0"
+	if st.Stalled {
+		stalled = "1"
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.w.Write([]string{
+		time.Now().UTC().Format(time.RFC3339Nano),
+		target,
+		st.Phase,
+		strconv.FormatUint(st.TX, 10),
+		strconv.FormatUint(st.RX, 10),
+		strconv.FormatUint(st.Bytes, 10),
+		strconv.FormatUint(st.InFlight, 10),
+		rtt(st.RTT.Min),
+		rtt(st.RTT.Avg()),
+		rtt(st.RTT.Max),
+		stalled,
+		strconv.FormatUint(st.MissedSlots, 10),
+		pacing(st.Pacing.Rate()),
+		pacing(st.Pacing.Jitter().Seconds()),
+		delay(st.Upstream),
+		delay(st.Downstream),
+	})
+	// Flush every interval, to plot runs in progress and keep the data of
+	// runs that are killed.
+	ts.w.Flush()
+}
+
+// Close flushes the time series and closes its file.
+func (ts *timeseries) Close() error {
+	if ts == nil {
+		return nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.w.Flush()
+	if err := ts.w.Error(); err != nil {
+		ts.file.Close()
+		return fmt.Errorf("write time series: %w", err)
+	}
+	return ts.file.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal/client"
+)
+
+func TestTimeseries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ts.tsv")
+
+	// Rows are appended across runs, with a single header.
+	for range 2 {
+		ts, err := openTimeseries(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.row("a", client.Stats{TX: 20, RX: 19, Bytes: 304, InFlight: 1, MissedSlots: 2, Phase: "upgrade",
+			Pacing:   client.SendIntervals{Count: 20, Sum: 400 * time.Millisecond},
+			Upstream: client.RTTStats{Count: 1, Sum: 2 * time.Millisecond}, Downstream: client.RTTStats{Count: 1, Sum: time.Millisecond},
+			RTT: client.RTTStats{Count: 2, Min: time.Millisecond, Max: 3 * time.Millisecond, Sum: 4 * time.Millisecond}})
+		ts.row("b", client.Stats{Stalled: true})
+		if err := ts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.Comma = '\t'
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 5 || !slices.Equal(rows[0], timeseriesHeader) {
+		t.Fatalf("unexpected rows %q", rows)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, rows[1][0]); err != nil {
+		t.Errorf("invalid timestamp: %s", err)
+	}
+	if want := []string{"a", "upgrade", "20", "19", "304", "1", "0.001", "0.002", "0.003", "0", "2", "50", "0", "0.002", "0.001"}; !slices.Equal(rows[1][1:], want) {
+		t.Errorf("got row %q, want %q", rows[1][1:], want)
+	}
+	if want := []string{"b", "", "0", "0", "0", "0", "", "", "", "1", "0", "", "", "", ""}; !slices.Equal(rows[4][1:], want) {
+		t.Errorf("got row %q, want %q", rows[4][1:], want)
+	}
+}
+
+func TestTimeseriesHeaderMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ts.csv")
+	if err := os.WriteFile(path, []byte("timestamp,target,tx,rx\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if ts, err := openTimeseries(path); err == nil {
+		ts.Close()
+		t.Fatal("expected appending to a file with other columns to fail")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "timestamp,target,tx,rx\n" {
+		t.Errorf("file changed to %q", data)
+	}
+}
@@ -0,0 +1,423 @@
+// Package analyze reconstructs the timeline of a recorded run from the event
+// logs of client and server, see package [events], and compares runs to
+// detect regressions.
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/events"
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+// closeSlack is how close a server-side close must be to a disruption window
+// to be correlated with it, and to the end of a target to be expected.
+const closeSlack = time.Second
+
+// Analysis is the reconstructed timeline and statistics of a run.
+type Analysis struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Targets []Target `json:"targets"`
+	// Windows are the periods in which at least one target stalled.
+	Windows []Window `json:"windows"`
+	// Phases holds the statistics of every phase, in order of appearance. Runs
+	// without phases have a single unnamed one.
+	Phases []Phase `json:"phases"`
+	// Latency is the distribution of round-trip times over the whole run.
+	Latency Latency `json:"latency"`
+	// ServerCloses are the connections the server saw closing unexpectedly,
+	// that is with an error or while the client was still running.
+	ServerCloses []ServerClose `json:"server_closes,omitempty"`
+	// Timeline lists the key events of the run in order.
+	Timeline []Entry `json:"timeline"`
+}
+
+// Target is the outcome of a client target.
+type Target struct {
+	Name          string  `json:"name"`
+	Local         string  `json:"local,omitempty"`
+	Remote        string  `json:"remote,omitempty"`
+	Sent          uint64  `json:"sent"`
+	Received      uint64  `json:"received"`
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+	Error         string  `json:"error,omitempty"`
+	Failure       string  `json:"failure,omitempty"`
+	Stalls        int     `json:"stalls"`
+
+	// done is when the target stopped, zero if not recorded.
+	done time.Time
+}
+
+// Window is a period of disruption, from the start of the first stall
+// overlapping it to the end of the last one.
+type Window struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Targets []string  `json:"targets"`
+	Phases  []string  `json:"phases,omitempty"`
+}
+
+// Duration returns the length of the window.
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// Phase holds the statistics of a phase across all targets. Rates are per
+// target and second.
+type Phase struct {
+	Name string `json:"name"`
+	// Intervals is the number of one-second intervals of all targets in the
+	// phase.
+	Intervals      int     `json:"intervals"`
+	TX             uint64  `json:"tx"`
+	RX             uint64  `json:"rx"`
+	OpsPerSecond   float64 `json:"ops_per_second"`
+	MinOps         uint64  `json:"min_ops"`
+	Stalls         int     `json:"stalls"`
+	StalledSeconds float64 `json:"stalled_seconds"`
+	Latency        Latency `json:"latency"`
+
+	// samples holds a value per interval for comparing runs.
+	samples map[string][]float64
+}
+
+// Latency summarizes a distribution of round-trip times.
+type Latency struct {
+	Count                    uint64
+	P50, P90, P99, P999, Max time.Duration
+}
+
+// MarshalJSON encodes the latency in seconds, like the reports.
+func (l Latency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count       uint64  `json:"count"`
+		P50Seconds  float64 `json:"p50_seconds"`
+		P90Seconds  float64 `json:"p90_seconds"`
+		P99Seconds  float64 `json:"p99_seconds"`
+		P999Seconds float64 `json:"p999_seconds"`
+		MaxSeconds  float64 `json:"max_seconds"`
+	}{l.Count, l.P50.Seconds(), l.P90.Seconds(), l.P99.Seconds(), l.P999.Seconds(), l.Max.Seconds()})
+}
+
+func newLatency(h *internal.Histogram) Latency {
+	return Latency{
+		Count: h.Count(),
+		P50:   h.Quantile(0.5),
+		P90:   h.Quantile(0.9),
+		P99:   h.Quantile(0.99),
+		P999:  h.Quantile(0.999),
+		Max:   h.Max(),
+	}
+}
+
+func (l Latency) String() string {
+	if l.Count == 0 {
+		return "no round-trip times"
+	}
+	return fmt.Sprintf("rtt p50 %s, p90 %s, p99 %s, p99.9 %s, max %s (%d samples)",
+		round(l.P50), round(l.P90), round(l.P99), round(l.P999), round(l.Max), l.Count)
+}
+
+// ServerClose is a connection closed unexpectedly, as seen by the server.
+type ServerClose struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+	// Target is the client target of the connection, if known.
+	Target string `json:"target,omitempty"`
+	// Window is the index of the disruption window the close is correlated
+	// with, or -1.
+	Window int `json:"window"`
+}
+
+// Entry is an event of the timeline.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Metrics compared between runs, sampled once per target and interval.
+const (
+	// MetricOps is the number of messages received.
+	MetricOps = "ops_per_second"
+	// MetricRTT is the median round-trip time in seconds.
+	MetricRTT = "rtt_p50_seconds"
+	// MetricStalled is 1 if the interval had a stall, 0 otherwise.
+	MetricStalled = "stalled"
+)
+
+// Analyze reconstructs a run from its client events and, optionally, server
+// events.
+func Analyze(client, server []events.Event) *Analysis {
+	all := slices.Concat(client, server)
+	slices.SortStableFunc(all, func(a, b events.Event) int { return a.Time.Compare(b.Time) })
+
+	a := &Analysis{}
+	if len(all) > 0 {
+		a.Start, a.End = all[0].Time, all[len(all)-1].Time
+	}
+
+	// Targets and phases are kept in order of appearance.
+	var targetNames, phaseNames []string
+	targets := make(map[string]*Target)
+	target := func(name string) *Target {
+		t, ok := targets[name]
+		if !ok {
+			t = &Target{Name: name}
+			targets[name] = t
+			targetNames = append(targetNames, name)
+		}
+		return t
+	}
+	phases := make(map[string]*Phase)
+	hists := make(map[string]*internal.Histogram)
+	phase := func(name string) *Phase {
+		p, ok := phases[name]
+		if !ok {
+			p = &Phase{Name: name, samples: make(map[string][]float64)}
+			phases[name] = p
+			hists[name] = &internal.Histogram{}
+			phaseNames = append(phaseNames, name)
+		}
+		return p
+	}
+	var total internal.Histogram
+	var stalls, closes []events.Event
+
+	for _, ev := range all {
+		switch ev.Kind {
+		case events.Connected:
+			t := target(ev.Target)
+			t.Local, t.Remote = ev.Local, ev.Remote
+			a.timeline(ev.Time, "%s connected from %s to %s", ev.Target, ev.Local, ev.Remote)
+
+		case events.Phase:
+			a.timeline(ev.Time, "Entering phase %s", ev.Phase)
+
+		case events.Interval:
+			p := phase(ev.Phase)
+			p.Intervals++
+			p.TX += ev.TX
+			p.RX += ev.RX
+			if p.Intervals == 1 || ev.RX < p.MinOps {
+				p.MinOps = ev.RX
+			}
+
+			var h internal.Histogram
+			h.AddBuckets(buckets(ev.RTTBuckets))
+			hists[ev.Phase].AddBuckets(buckets(ev.RTTBuckets))
+			total.AddBuckets(buckets(ev.RTTBuckets))
+
+			p.samples[MetricOps] = append(p.samples[MetricOps], float64(ev.RX))
+			if h.Count() > 0 {
+				p.samples[MetricRTT] = append(p.samples[MetricRTT], h.Quantile(0.5).Seconds())
+			}
+			p.samples[MetricStalled] = append(p.samples[MetricStalled], boolFloat(ev.Stalled))
+
+		case events.Stall:
+			stalls = append(stalls, ev)
+			target(ev.Target).Stalls++
+			p := phase(ev.Phase)
+			p.Stalls++
+			p.StalledSeconds += ev.DurationSeconds
+
+		case events.Done:
+			t := target(ev.Target)
+			t.Sent, t.Received, t.MaxGapSeconds = ev.Sent, ev.Received, ev.MaxGapSeconds
+			t.Error, t.Failure, t.done = ev.Error, ev.Failure, ev.Time
+			if ev.Error != "" {
+				a.timeline(ev.Time, "%s failed (%s): %s", ev.Target, ev.Failure, ev.Error)
+			} else {
+				a.timeline(ev.Time, "%s done, sent %d and received %d messages", ev.Target, ev.Sent, ev.Received)
+			}
+
+		case events.Closed:
+			if ev.Source == events.SourceServer && ev.Reason != events.CloseShutdown {
+				closes = append(closes, ev)
+			}
+		}
+	}
+
+	for _, name := range targetNames {
+		a.Targets = append(a.Targets, *targets[name])
+	}
+	for _, name := range phaseNames {
+		p := phases[name]
+		p.Latency = newLatency(hists[name])
+		if p.Intervals > 0 {
+			p.OpsPerSecond = float64(p.RX) / float64(p.Intervals)
+		}
+		a.Phases = append(a.Phases, *p)
+	}
+	a.Latency = newLatency(&total)
+
+	a.windows(stalls)
+	a.serverCloses(closes)
+	slices.SortStableFunc(a.Timeline, func(a, b Entry) int { return a.Time.Compare(b.Time) })
+	return a
+}
+
+// windows merges overlapping stalls of all targets into disruption windows.
+func (a *Analysis) windows(stalls []events.Event) {
+	slices.SortStableFunc(stalls, func(a, b events.Event) int { return a.Start.Compare(b.Start) })
+	for _, s := range stalls {
+		end := s.Start.Add(time.Duration(s.DurationSeconds * float64(time.Second)))
+		if n := len(a.Windows); n > 0 && !s.Start.After(a.Windows[n-1].End) {
+			w := &a.Windows[n-1]
+			w.End = maxTime(w.End, end)
+			w.Targets = appendUnique(w.Targets, s.Target)
+			w.Phases = appendUnique(w.Phases, s.Phase)
+			continue
+		}
+		w := Window{Start: s.Start, End: end, Targets: []string{s.Target}}
+		w.Phases = appendUnique(w.Phases, s.Phase)
+		a.Windows = append(a.Windows, w)
+	}
+
+	for _, w := range a.Windows {
+		line := fmt.Sprintf("Disruption of %s affecting %s", round(w.Duration()), strings.Join(w.Targets, ", "))
+		if len(w.Phases) > 0 {
+			line += " in phase " + strings.Join(w.Phases, ", ")
+		}
+		a.timeline(w.Start, "%s", line)
+	}
+}
+
+// serverCloses correlates the closes seen by the server with targets and
+// disruption windows. Closes by the peer are expected when the target
+// stopped around the same time.
+func (a *Analysis) serverCloses(closes []events.Event) {
+	for _, ev := range closes {
+		c := ServerClose{Time: ev.Time, Remote: ev.Remote, Reason: ev.Reason, Error: ev.Error, Window: -1}
+		var t *Target
+		for i := range a.Targets {
+			if a.Targets[i].Local != "" && a.Targets[i].Local == ev.Remote {
+				t = &a.Targets[i]
+				c.Target = t.Name
+			}
+		}
+		if ev.Reason == events.ClosePeer && t != nil && !t.done.IsZero() && absDuration(ev.Time.Sub(t.done)) <= closeSlack {
+			continue
+		}
+		for i, w := range a.Windows {
+			if !ev.Time.Before(w.Start.Add(-closeSlack)) && !ev.Time.After(w.End.Add(closeSlack)) {
+				c.Window = i
+			}
+		}
+		a.ServerCloses = append(a.ServerCloses, c)
+
+		line := fmt.Sprintf("Server saw %s close connection from %s", c.Reason, c.Remote)
+		if c.Target != "" {
+			line += " (" + c.Target + ")"
+		}
+		if c.Error != "" {
+			line += ": " + c.Error
+		}
+		if c.Window >= 0 {
+			line += fmt.Sprintf(", during disruption %d", c.Window+1)
+		}
+		a.timeline(c.Time, "%s", line)
+	}
+}
+
+func (a *Analysis) timeline(t time.Time, format string, args ...any) {
+	a.Timeline = append(a.Timeline, Entry{Time: t, Message: fmt.Sprintf(format, args...)})
+}
+
+func (a *Analysis) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run from %s to %s (%s)\n", a.Start.UTC().Format(time.RFC3339Nano), a.End.UTC().Format(time.RFC3339Nano), round(a.End.Sub(a.Start)))
+
+	b.WriteString("\nTimeline:\n")
+	for _, e := range a.Timeline {
+		fmt.Fprintf(&b, "  +%-10s %s\n", report.Seconds(e.Time.Sub(a.Start).Seconds()), e.Message)
+	}
+
+	fmt.Fprintf(&b, "\n%d disruption windows", len(a.Windows))
+	if len(a.Windows) > 0 {
+		var total time.Duration
+		for _, w := range a.Windows {
+			total += w.Duration()
+		}
+		fmt.Fprintf(&b, " totalling %s", round(total))
+	}
+	b.WriteString("\n")
+
+	b.WriteString("\nTargets:\n")
+	for _, t := range a.Targets {
+		fmt.Fprintf(&b, "  %s: sent %d, received %d, longest gap %s, %d stalls", t.Name, t.Sent, t.Received, report.Seconds(t.MaxGapSeconds), t.Stalls)
+		if t.Error != "" {
+			fmt.Fprintf(&b, ", failed (%s): %s", t.Failure, t.Error)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nPhases:\n")
+	for _, p := range a.Phases {
+		fmt.Fprintf(&b, "  %s: %d intervals, %.1f ops/s (min %d), %d stalls (%s), %s\n",
+			phaseName(p.Name), p.Intervals, p.OpsPerSecond, p.MinOps, p.Stalls, report.Seconds(p.StalledSeconds), p.Latency)
+	}
+
+	fmt.Fprintf(&b, "\nOverall %s", a.Latency)
+	return b.String()
+}
+
+func phaseName(name string) string {
+	if name == "" {
+		return "(no phase)"
+	}
+	return name
+}
+
+func buckets(pairs [][2]uint64) []internal.Bucket {
+	bs := make([]internal.Bucket, len(pairs))
+	for i, p := range pairs {
+		bs[i] = internal.Bucket{Upper: time.Duration(p[0]), Count: p[1]}
+	}
+	return bs
+}
+
+func appendUnique[T comparable](s []T, v T) []T {
+	var zero T
+	if v == zero || slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func absDuration(d time.Duration) time.Duration {
+	return max(d, -d)
+}
+
+// round rounds durations for display.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,151 @@
+package analyze
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/events"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// run returns the client events of a run of two targets over 20 seconds, with
+// a phase "upgrade" from second 10 in which both stall around second 12. The
+// rate and round-trip times of the upgrade phase are scaled by the given
+// factors.
+func run(rng *rand.Rand, rateFactor, rttFactor float64) []events.Event {
+	at := func(s float64) time.Time { return start.Add(time.Duration(s * float64(time.Second))) }
+	var evs []events.Event
+	for _, target := range []string{"a", "b"} {
+		evs = append(evs, events.Event{Time: at(0.1), Kind: events.Connected, Target: target, Local: "10.0.0.1:1000" + target, Remote: "10.0.0.2:8000"})
+	}
+	evs = append(evs, events.Event{Time: at(10), Kind: events.Phase, Phase: "upgrade"})
+	for s := 1; s <= 20; s++ {
+		phase, rate, rtt := "", 1000.0, time.Millisecond
+		if s > 10 {
+			phase, rate, rtt = "upgrade", rate*rateFactor, time.Duration(float64(rtt)*rttFactor)
+		}
+		for _, target := range []string{"a", "b"} {
+			var h internal.Histogram
+			n := int(rate * (0.95 + rng.Float64()*0.1))
+			for range n {
+				h.Add(time.Duration(float64(rtt) * (0.5 + rng.Float64())))
+			}
+			var pairs [][2]uint64
+			for _, b := range h.Buckets() {
+				pairs = append(pairs, [2]uint64{uint64(b.Upper), b.Count})
+			}
+			evs = append(evs, events.Event{
+				Time: at(float64(s)), Kind: events.Interval, Target: target, Phase: phase,
+				TX: uint64(n), RX: uint64(n), Stalled: s == 13, RTTBuckets: pairs,
+			})
+		}
+	}
+	evs = append(evs,
+		events.Event{Time: at(13), Kind: events.Stall, Target: "a", Phase: "upgrade", Start: at(12), DurationSeconds: 1},
+		events.Event{Time: at(13.5), Kind: events.Stall, Target: "b", Phase: "upgrade", Start: at(12.5), DurationSeconds: 1},
+		events.Event{Time: at(20), Kind: events.Done, Target: "a", Sent: 20000, Received: 20000, MaxGapSeconds: 1},
+		events.Event{Time: at(20), Kind: events.Done, Target: "b", Sent: 20000, Received: 19990, MaxGapSeconds: 1, Error: "timeout", Failure: "timeout"},
+	)
+	return evs
+}
+
+func TestAnalyze(t *testing.T) {
+	// Go through the log format.
+	var buf bytes.Buffer
+	log := events.New(&buf, events.SourceServer)
+	log.Info(events.Closed, "local", "10.0.0.2:8000", "remote", "10.0.0.1:1000a", "reason", events.CloseError, "error", "connection reset by peer")
+	log.Info(events.Closed, "local", "10.0.0.2:8000", "remote", "10.0.0.1:1000b", "reason", events.ClosePeer)
+	log.Info(events.Closed, "local", "10.0.0.2:8000", "remote", "10.0.0.1:9999", "reason", events.CloseShutdown)
+	server, err := events.Read(&buf)
+	if err != nil || len(server) != 3 {
+		t.Fatalf("read server events: %d, %v", len(server), err)
+	}
+	// Place the closes in the timeline: during the disruption, at the end of
+	// b, and at shutdown.
+	server[0].Time = start.Add(12500 * time.Millisecond)
+	server[1].Time = start.Add(20 * time.Second)
+	server[2].Time = start.Add(21 * time.Second)
+
+	a := Analyze(run(rand.New(rand.NewPCG(1, 1)), 1, 1), server)
+	t.Log(a)
+
+	if len(a.Targets) != 2 || a.Targets[0].Name != "a" || a.Targets[1].Error != "timeout" {
+		t.Errorf("unexpected targets: %+v", a.Targets)
+	}
+	if len(a.Windows) != 1 {
+		t.Fatalf("expected 1 disruption window, got %+v", a.Windows)
+	}
+	if w := a.Windows[0]; w.Duration() != 1500*time.Millisecond || len(w.Targets) != 2 || len(w.Phases) != 1 {
+		t.Errorf("unexpected window: %+v", w)
+	}
+
+	if len(a.Phases) != 2 || a.Phases[0].Name != "" || a.Phases[1].Name != "upgrade" {
+		t.Fatalf("unexpected phases: %+v", a.Phases)
+	}
+	upgrade := a.Phases[1]
+	if upgrade.Intervals != 20 || upgrade.Stalls != 2 || upgrade.StalledSeconds != 2 {
+		t.Errorf("unexpected upgrade phase: %+v", upgrade)
+	}
+	if upgrade.OpsPerSecond < 950 || upgrade.OpsPerSecond > 1050 {
+		t.Errorf("unexpected rate %f", upgrade.OpsPerSecond)
+	}
+	// Round-trip times are uniform between 0.5 and 1.5ms.
+	l := a.Latency
+	if l.Count != a.Phases[0].Latency.Count+upgrade.Latency.Count {
+		t.Errorf("overall latency doesn't add up: %+v", l)
+	}
+	if l.P50 < 950*time.Microsecond || l.P50 > 1100*time.Microsecond || l.Max > 1600*time.Microsecond {
+		t.Errorf("unexpected latency %s", l)
+	}
+
+	if len(a.ServerCloses) != 1 {
+		t.Fatalf("expected one unexpected close, got %+v", a.ServerCloses)
+	}
+	if c := a.ServerCloses[0]; c.Target != "a" || c.Window != 0 || c.Reason != events.CloseError {
+		t.Errorf("unexpected close: %+v", c)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	baseline := Analyze(run(rng, 1, 1), nil)
+
+	cs := Compare(baseline, Analyze(run(rng, 1, 1), nil))
+	t.Log(cs)
+	if r := cs.Regressions(); len(r) != 0 {
+		t.Errorf("same runs regressed: %v", r)
+	}
+
+	// Half the rate and twice the round-trip times in the upgrade phase.
+	cs = Compare(baseline, Analyze(run(rng, 0.5, 2), nil))
+	t.Log(cs)
+	r := cs.Regressions()
+	if len(r) != 2 || r[0].Phase != "upgrade" || r[0].Metric != MetricOps || r[1].Metric != MetricRTT {
+		t.Errorf("expected regressions of rate and rtt in upgrade, got %v", r)
+	}
+
+	// Improvements aren't regressions.
+	cs = Compare(baseline, Analyze(run(rng, 2, 0.5), nil))
+	if r := cs.Regressions(); len(r) != 0 {
+		t.Errorf("improvements regressed: %v", r)
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := mannWhitney(x, x); p < 0.9 {
+		t.Errorf("identical samples: p=%f", p)
+	}
+	y := []float64{11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	// Exact two-sided p-value is 1.08e-5, the approximation is close.
+	if p := mannWhitney(x, y); p > 1e-3 {
+		t.Errorf("disjoint samples: p=%f", p)
+	}
+	if p := mannWhitney([]float64{1, 1, 1}, []float64{1, 1, 1}); p != 1 {
+		t.Errorf("equal samples: p=%f", p)
+	}
+}
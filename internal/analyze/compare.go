@@ -0,0 +1,180 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	// significance is the p-value below which a difference between runs is
+	// taken to be real rather than noise.
+	significance = 0.01
+	// minChange is the relative change of a metric below which a significant
+	// difference is still not flagged, as long runs make small differences
+	// significant.
+	minChange = 0.05
+	// minSamples is the number of intervals both runs need for a metric to
+	// be compared.
+	minSamples = 5
+)
+
+// Comparison is the difference of a metric in a phase between a baseline run
+// and the current one.
+type Comparison struct {
+	Phase  string `json:"phase"`
+	Metric string `json:"metric"`
+	// Baseline and Current are the means of the metric over the intervals.
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// P is the p-value of the two-sided Mann-Whitney U test, the probability
+	// of a difference at least this large between runs of the same
+	// distribution.
+	P float64 `json:"p"`
+	// Regression is whether the current run is significantly worse.
+	Regression bool `json:"regression"`
+}
+
+func (c Comparison) String() string {
+	s := fmt.Sprintf("%s %s: %.4g -> %.4g (p=%.3g)", phaseName(c.Phase), c.Metric, c.Baseline, c.Current, c.P)
+	if c.Regression {
+		s += " REGRESSION"
+	}
+	return s
+}
+
+// Comparisons are the results of comparing two runs.
+type Comparisons []Comparison
+
+// Regressions returns the comparisons that are regressions.
+func (cs Comparisons) Regressions() Comparisons {
+	var r Comparisons
+	for _, c := range cs {
+		if c.Regression {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+func (cs Comparisons) String() string {
+	var b strings.Builder
+	for _, c := range cs {
+		fmt.Fprintln(&b, c)
+	}
+	fmt.Fprintf(&b, "%d regressions", len(cs.Regressions()))
+	return b.String()
+}
+
+// higherIsWorse tells for every compared metric in which direction it
+// regresses.
+var higherIsWorse = map[string]bool{
+	MetricOps:     false,
+	MetricRTT:     true,
+	MetricStalled: true,
+}
+
+// Compare compares the phases the baseline and current run have in common.
+// A metric regresses if its distribution over the intervals of a phase moved
+// in the worse direction, significantly according to the Mann-Whitney U
+// test and by a relevant amount.
+func Compare(baseline, current *Analysis) Comparisons {
+	var cs Comparisons
+	for _, cur := range current.Phases {
+		i := slices.IndexFunc(baseline.Phases, func(p Phase) bool { return p.Name == cur.Name })
+		if i < 0 {
+			continue
+		}
+		base := baseline.Phases[i]
+		for _, metric := range []string{MetricOps, MetricRTT, MetricStalled} {
+			x, y := base.samples[metric], cur.samples[metric]
+			if len(x) < minSamples || len(y) < minSamples {
+				continue
+			}
+			c := Comparison{
+				Phase:    cur.Name,
+				Metric:   metric,
+				Baseline: mean(x),
+				Current:  mean(y),
+				P:        mannWhitney(x, y),
+			}
+			worse := c.Current < c.Baseline
+			if higherIsWorse[metric] {
+				worse = c.Current > c.Baseline
+			}
+			change := math.Abs(c.Current-c.Baseline) / math.Max(math.Abs(c.Baseline), math.SmallestNonzeroFloat64)
+			// Stalls are rare, any significant increase counts.
+			relevant := metric == MetricStalled || change >= minChange
+			c.Regression = worse && relevant && c.P < significance
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test of
+// samples x and y, using the normal approximation with correction for ties
+// and continuity.
+func mannWhitney(x, y []float64) float64 {
+	type sample struct {
+		v     float64
+		first bool
+	}
+	all := make([]sample, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	slices.SortFunc(all, func(a, b sample) int { return cmpFloat(a.v, b.v) })
+
+	// Rank with ties getting the average of their ranks.
+	var rankSum, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, s := range all[i:j] {
+			if s.first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(x)), float64(len(y))
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * (n + 1 - ties/(n*(n-1))))
+	if sigma == 0 {
+		// All samples are equal.
+		return 1
+	}
+	z := max(math.Abs(u-mu)-0.5, 0) / sigma
+	return math.Erfc(z / math.Sqrt2)
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func mean(s []float64) float64 {
+	var sum float64
+	for _, v := range s {
+		sum += v
+	}
+	return sum / float64(len(s))
+}
@@ -0,0 +1,857 @@
+// Package client implements the connection disruption test client. It sends
+// messages to the server at a steady pace and fails when the flow stalls for
+// longer than the configured timeout or a message doesn't make it through
+// intact.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/events"
+	"github.com/cilium/test-connection-disruption/internal/phase"
+)
+
+// Config configures a Client.
+type Config struct {
+	// Addr is the address of the server.
+	Addr string
+	// Transport is one of [internal.Transports].
+	Transport string
+	// Mode is one of [internal.Modes] and must match the server's mode.
+	Mode string
+	// Interval is the target interval between two messages.
+	Interval time.Duration
+	// Timeout is the longest time the client waits for a reply.
+	Timeout time.Duration
+	// Rate makes the client send this many messages per second as an open
+	// loop, on an absolute timeline following the Arrival process, instead of
+	// pacing sends Interval apart. Round-trip times are measured from when a
+	// message was due rather than when it was sent, so they include delays of
+	// the client itself. Interval defaults to the mean interval then. 0
+	// disables.
+	Rate float64
+	// Arrival is one of [internal.Arrivals]. Defaults to constant.
+	Arrival string
+
+	// HalfClose makes the client half-close the connection on shutdown and
+	// verify all replies arrived before closing it. In reverse mode, the client
+	// answers the server's half-close instead.
+	HalfClose bool
+	// ProxyProtocol is the version of the PROXY protocol header sent at the start
+	// of the connection, or 0 to send none.
+	ProxyProtocol int
+	// OneWayDelay makes the client expect replies stamped by the server in
+	// echo mode, see [server.Config.Stamp], to measure the delay in each
+	// direction and tell which one stalls occurred in.
+	OneWayDelay bool
+	// ICMPInterval is the interval of ICMP echo requests sent to the server's
+	// host alongside the flow, or 0 to send none.
+	ICMPInterval time.Duration
+	// Socket holds options for the client's socket.
+	Socket internal.SocketOptions
+	// Scheduling configures the thread sending requests, see
+	// [internal.Scheduling.ApplyThread]. The zero value keeps the process'
+	// settings.
+	Scheduling internal.Scheduling
+
+	// DialAttempts is the number of connection attempts, one second apart,
+	// before giving up.
+	DialAttempts int
+	// ConnectTimeout bounds every connection attempt. 0 waits for the OS to
+	// give up.
+	ConnectTimeout time.Duration
+	// Log receives progress messages. Defaults to [internal.DefaultLogger].
+	Log *slog.Logger
+	// OnReady is called once the connection is established and traffic is
+	// flowing.
+	OnReady func()
+	// OnStats is called with the counters of every one second interval.
+	OnStats func(Stats)
+	// OnStall is called when a stall ends, or when the client fails during
+	// one.
+	OnStall func(Stall)
+	// QuietStats disables the per-second stats message and OnStats, for callers
+	// that collect the counters with [Client.TakeStats] themselves.
+	QuietStats bool
+
+	// Phases tracks the phases of the test, to break down results by phase.
+	// Optional.
+	Phases *phase.Tracker
+	// StallThreshold is the shortest gap between two received messages that
+	// counts as a stall. Defaults to five intervals, or twenty with Poisson
+	// arrivals.
+	StallThreshold time.Duration
+	// Thresholds are the criteria the run must meet, on top of the timeout.
+	Thresholds Thresholds
+	// Events receives the client's connection and stalls for the event log,
+	// see package [events]. Optional.
+	Events *slog.Logger
+}
+
+// Stats holds the counters of a one second interval.
+type Stats struct {
+	// Number of messages sent and received.
+	TX, RX uint64
+	// Number of bytes received.
+	Bytes uint64
+	// Number of ICMP echo requests sent and replies received.
+	ICMPTX, ICMPRX uint64
+	// Phase is the phase of the test at the end of the interval, if tracked.
+	Phase string
+	// InFlight is the number of requests without a reply at the end of the
+	// interval, in echo mode.
+	InFlight uint64
+	// RTT summarizes the round-trip times of the interval, and RTTBuckets
+	// holds their distribution, in echo mode.
+	RTT        RTTStats
+	RTTBuckets []internal.Bucket
+	// Stalled is set if a stall ended during the interval, or is ongoing at
+	// its end.
+	Stalled bool
+	// MissedSlots is the number of send slots missed in rate mode, see
+	// [Result].
+	MissedSlots uint64
+	// Pacing summarizes the intervals between requests sent, and TargetRate
+	// is the number of requests per second the client aims for, in echo and
+	// duplex mode.
+	Pacing     SendIntervals
+	TargetRate float64
+	// Upstream and Downstream summarize the delays from client to server and
+	// back, and ClockOffset is the estimated offset of the server's clock,
+	// with OneWayDelay.
+	Upstream, Downstream RTTStats
+	ClockOffset          time.Duration
+}
+
+// attrs returns the counters as log attributes.
+func (st Stats) attrs() []any {
+	attrs := []any{"tx", st.TX, "rx", st.RX, "bytes", st.Bytes}
+	if st.ICMPTX > 0 || st.ICMPRX > 0 {
+		attrs = append(attrs, "icmp_tx", st.ICMPTX, "icmp_rx", st.ICMPRX)
+	}
+	if st.Pacing.Count > 0 {
+		attrs = append(attrs, "send_rate", math.Round(st.Pacing.Rate()*10)/10, "target_rate", st.TargetRate, "jitter", st.Pacing.Jitter())
+	}
+	if st.MissedSlots > 0 {
+		attrs = append(attrs, "missed_slots", st.MissedSlots)
+	}
+	if st.Upstream.Count > 0 {
+		attrs = append(attrs, "delay_up", st.Upstream.Avg(), "delay_down", st.Downstream.Avg(), "clock_offset", st.ClockOffset)
+	}
+	if st.Phase != "" {
+		attrs = append(attrs, "phase", st.Phase)
+	}
+	return attrs
+}
+
+// Result summarizes a client run.
+type Result struct {
+	// Total number of messages sent and received.
+	Sent, Received uint64
+	// MaxGap is the longest time the client went without receiving a message,
+	// counting from when the connection was established.
+	MaxGap time.Duration
+	// Duration is the time from establishing the connection until the client
+	// stopped.
+	Duration time.Duration
+	// Lost is the number of requests left without a reply once the client
+	// stopped, in echo mode.
+	Lost uint64
+	// Stalls is the number of gaps longer than the stall threshold, and Stalled
+	// their total duration. StallList holds the first of them.
+	Stalls    int
+	Stalled   time.Duration
+	StallList []Stall
+	// RTT summarizes round-trip times, and RTTP99 is their 99th percentile, in
+	// echo mode.
+	RTT    RTTStats
+	RTTP99 time.Duration
+	// MinOpsPerSecond is the fewest messages received in any of the Intervals
+	// full one-second intervals measured.
+	MinOpsPerSecond uint64
+	Intervals       int
+	// DialRetries is the number of times the client retried connecting.
+	DialRetries int
+	// MissedSlots is the number of send slots the client didn't meet in rate
+	// mode, as it sent a full interval late or not at all, and DroppedSlots
+	// those it didn't send as it fell too far behind. Either points at the
+	// client being starved rather than the network.
+	MissedSlots, DroppedSlots uint64
+	// Pacing summarizes the intervals between requests sent, PacingP99 is
+	// their 99th percentile and TargetRate the rate the client aimed for, see
+	// [Stats]. ClientBound is the number of seconds in which the client itself
+	// kept from sending at the target rate, rather than the network.
+	Pacing      SendIntervals
+	PacingP99   time.Duration
+	TargetRate  float64
+	ClientBound int
+	// Upstream and Downstream summarize the delays from client to server and
+	// back, and ClockOffset is the estimated offset of the server's clock at
+	// the end, with OneWayDelay.
+	Upstream, Downstream RTTStats
+	ClockOffset          time.Duration
+	// Phases breaks down the results by phase, if tracked.
+	Phases []PhaseResult
+	// ClientToServer and ServerToClient break down the messages by direction,
+	// as far as the client can tell, see [DirectionResult].
+	ClientToServer, ServerToClient DirectionResult
+}
+
+// DirectionResult summarizes the messages sent in one direction. The client
+// knows how many it sent itself and how many it received from the server, and
+// the longest gap between them. It only learns how many of its own messages
+// arrived from the replies in echo mode, and how far apart from the server's
+// stamps with OneWayDelay; the fields it can't tell are left zero, and the
+// server reports them instead.
+type DirectionResult struct {
+	Sent, Received uint64
+	MaxGap         time.Duration
+}
+
+// stats holds the counters of a client.
+type stats struct {
+	// Per-second counters, reset by the logger.
+	rx, tx atomic.Uint64
+	bytes  atomic.Uint64
+
+	// Totals over the lifetime of the client.
+	sent, received atomic.Uint64
+	// Messages received in the current second, reset by the monitor.
+	second atomic.Uint64
+	// Whether a stall ended since the last per-second report.
+	stalled atomic.Bool
+
+	// Unix timestamp in nanoseconds of the last message received, and the
+	// longest gap between two of them in nanoseconds.
+	lastReceived, maxGap atomic.Int64
+
+	icmpRx, icmpTx atomic.Uint64
+	// Unix timestamp in nanoseconds of the last ICMP echo reply.
+	icmpLast atomic.Int64
+	// ICMP echo requests sent and replies received in total, and as of the
+	// last message received, to tell the ICMP loss during a stall.
+	icmpSent, icmpReceived     atomic.Uint64
+	icmpSentAt, icmpReceivedAt atomic.Uint64
+
+	// Send slots missed in rate mode, per second, in the current second for
+	// the monitor and in total, and those dropped.
+	missed, missedSecond, missedTotal, dropped atomic.Uint64
+}
+
+// receive accounts for a valid message received from the server and returns
+// the gap since the previous one, along with the ICMP echo requests sent and
+// replies received during it. Must not be called concurrently.
+func (s *stats) receive() (time.Duration, icmpCounts) {
+	icmp := s.icmpSinceReceived()
+	s.icmpSentAt.Add(icmp.sent)
+	s.icmpReceivedAt.Add(icmp.received)
+
+	now := time.Now().UnixNano()
+	gap := now - s.lastReceived.Swap(now)
+	if gap > s.maxGap.Load() {
+		s.maxGap.Store(gap)
+	}
+
+	s.rx.Add(1)
+	s.bytes.Add(internal.MsgSize)
+	s.received.Add(1)
+	s.second.Add(1)
+
+	return time.Duration(gap), icmp
+}
+
+// icmpCounts are the ICMP echo requests sent and replies received in a window.
+type icmpCounts struct {
+	sent, received uint64
+}
+
+// icmpSinceReceived returns the ICMP echo requests sent and replies received
+// since the last message received.
+func (s *stats) icmpSinceReceived() icmpCounts {
+	return icmpCounts{
+		sent:     s.icmpSent.Load() - s.icmpSentAt.Load(),
+		received: s.icmpReceived.Load() - s.icmpReceivedAt.Load(),
+	}
+}
+
+// Client is a connection disruption test client.
+type Client struct {
+	cfg       Config
+	log       *slog.Logger
+	stats     stats
+	phases    phaseStats
+	sendTimes sendTimes
+	rtt       rttStats
+	pacing    pacingStats
+	owd       oneWayStats
+	rates     rates
+	stalls    stalls
+	// dialRetries is the number of connection retries.
+	dialRetries atomic.Int64
+
+	// Unix timestamps in nanoseconds of when the connection was established
+	// and the client stopped.
+	start, end atomic.Int64
+	// failed is set if the run ended with an error.
+	failed atomic.Bool
+}
+
+// New returns a client for the given config.
+func New(cfg Config) *Client {
+	c := &Client{cfg: cfg, log: cfg.Log}
+	if c.log == nil {
+		c.log = internal.DefaultLogger()
+	}
+	if c.cfg.DialAttempts <= 0 {
+		c.cfg.DialAttempts = 1
+	}
+	if c.cfg.Rate > 0 {
+		c.cfg.Interval = max(time.Duration(float64(time.Second)/c.cfg.Rate), 1)
+	}
+	if c.cfg.Arrival == "" {
+		c.cfg.Arrival = internal.ArrivalConstant
+	}
+	if c.cfg.StallThreshold <= 0 {
+		c.cfg.StallThreshold = 5 * c.cfg.Interval
+		if c.cfg.Rate > 0 && c.cfg.Arrival == internal.ArrivalPoisson {
+			// Exponentially distributed intervals exceed five times their mean
+			// once in 150, but twenty times only once in half a billion.
+			c.cfg.StallThreshold = 20 * c.cfg.Interval
+		}
+	}
+	return c
+}
+
+// Run connects to the server and exchanges messages until ctx is cancelled or
+// the server closes the connection. Returns an error on connection failure, a
+// stall exceeding the timeout, invalid data or a violated threshold.
+func (c *Client) Run(ctx context.Context) (err error) {
+	if !slices.Contains(internal.Modes, c.cfg.Mode) {
+		return fmt.Errorf("unsupported mode %q", c.cfg.Mode)
+	}
+	if c.cfg.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", c.cfg.Interval)
+	}
+	if c.cfg.OneWayDelay && c.cfg.Mode != internal.ModeEcho {
+		return fmt.Errorf("one-way delays are only measured in %s mode", internal.ModeEcho)
+	}
+	if err := c.cfg.Scheduling.Validate(); err != nil {
+		return err
+	}
+	// Find out about missing privileges before connecting, rather than once
+	// the writer starts.
+	if err := c.cfg.Scheduling.Check(); err != nil {
+		return err
+	}
+	var schedule *internal.Schedule
+	if c.cfg.Rate > 0 {
+		var err error
+		if schedule, err = internal.NewSchedule(c.cfg.Rate, c.cfg.Arrival); err != nil {
+			return err
+		}
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		if c.cfg.DialAttempts > 1 {
+			err = fmt.Errorf("after %d attempts: %w", c.cfg.DialAttempts, err)
+		}
+		return withKind(KindDial, fmt.Errorf("dial remote: %w", err))
+	}
+	defer conn.Close()
+	c.log = c.log.With(internal.ConnAttrs(conn))
+
+	start := time.Now().UnixNano()
+	c.start.Store(start)
+	c.stats.lastReceived.Store(start)
+	defer func() {
+		end := time.Now()
+		c.end.Store(end.UnixNano())
+		// Record the stall the client failed in, if any.
+		if last := time.Unix(0, c.stats.lastReceived.Load()); err != nil && end.Sub(last) > c.cfg.StallThreshold {
+			c.reportStall(c.newStall(last, end.Sub(last), c.stats.icmpSinceReceived()))
+		}
+		if err == nil {
+			err = c.cfg.Thresholds.Check(c.Result())
+		}
+		c.failed.Store(err != nil)
+	}()
+
+	// Set up request payload.
+	payload, err := internal.NewPayload()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if c.cfg.ICMPInterval > 0 {
+		prober, err := newICMPProber(c.cfg.Addr, &c.stats, c.log)
+		if err != nil {
+			return fmt.Errorf("set up ICMP prober: %w", err)
+		}
+		defer prober.Close()
+
+		c.log.Info("Sending ICMP echo requests", "interval", c.cfg.ICMPInterval)
+		go prober.run(ctx, c.cfg.ICMPInterval)
+	}
+
+	var eg errgroup.Group
+	switch c.cfg.Mode {
+	case internal.ModeEcho:
+		eg.Go(c.writer(ctx, cancel, conn, &payload, schedule))
+		eg.Go(c.reader(ctx, cancel, conn, &payload))
+	case internal.ModeReverse:
+		eg.Go(c.echo(ctx, conn))
+	case internal.ModeDuplex:
+		eg.Go(c.writer(ctx, cancel, conn, &payload, schedule))
+		eg.Go(c.streamReader(ctx, cancel, conn))
+	}
+
+	// The logger outlives the errgroup's context, stop it separately.
+	loggerCtx, stopLogger := context.WithCancel(context.Background())
+	defer stopLogger()
+	if !c.cfg.QuietStats {
+		go c.logger(loggerCtx)
+	}
+
+	violation := make(chan error, 1)
+	go func() { violation <- c.monitor(ctx, cancel) }()
+
+	if c.cfg.OnReady != nil {
+		c.cfg.OnReady()
+	}
+
+	err = eg.Wait()
+	cancel()
+	// A violated threshold stopped the client, any error is a consequence.
+	if err := <-violation; err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("writer or reader: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	for attempt := range c.cfg.DialAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, err
+			case <-time.After(time.Second):
+			}
+		}
+
+		if attempt > 0 {
+			c.dialRetries.Add(1)
+		}
+		conn, err = internal.Dial(c.cfg.Transport, c.cfg.Addr, c.cfg.ConnectTimeout, c.cfg.Socket)
+		if err == nil {
+			break
+		}
+		c.log.Warn("Failed to connect, retrying", "addr", c.cfg.Addr, "attempt", attempt+1, "error", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.Info("Connected", internal.ConnAttrs(conn), "transport", c.cfg.Transport)
+	c.event(events.Connected, internal.ConnAttrs(conn))
+
+	if c.cfg.ProxyProtocol != 0 {
+		if err := internal.WriteProxyHeader(conn, c.cfg.ProxyProtocol); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// reportStall passes a stall to the event log and OnStall.
+func (c *Client) reportStall(stall Stall) {
+	args := []any{"start", stall.Start, "duration_seconds", stall.Duration.Seconds()}
+	if stall.Direction != "" {
+		args = append(args, "direction", stall.Direction)
+	}
+	if c.cfg.ICMPInterval > 0 {
+		args = append(args, "icmp_sent", stall.ICMPSent, "icmp_received", stall.ICMPReceived)
+	}
+	c.event(events.Stall, args...)
+	if c.cfg.OnStall != nil {
+		c.cfg.OnStall(stall)
+	}
+}
+
+// event logs an event of the given kind to the event log, if any.
+func (c *Client) event(kind string, args ...any) {
+	if c.cfg.Events == nil {
+		return
+	}
+	if phase := c.phase(); phase != "" {
+		args = append(args, "phase", phase)
+	}
+	c.cfg.Events.Info(kind, args...)
+}
+
+func (c *Client) logger(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st := c.TakeStats()
+
+		c.log.Info("Operations per second", st.attrs()...)
+
+		if c.cfg.OnStats != nil {
+			c.cfg.OnStats(st)
+		}
+	}
+}
+
+// TakeStats returns the counters since the last call and resets them.
+func (c *Client) TakeStats() Stats {
+	st := Stats{
+		TX:      c.stats.tx.Swap(0),
+		RX:      c.stats.rx.Swap(0),
+		Bytes:   c.stats.bytes.Swap(0),
+		ICMPTX:  c.stats.icmpTx.Swap(0),
+		ICMPRX:  c.stats.icmpRx.Swap(0),
+		Phase:   c.phase(),
+		Stalled: c.stats.stalled.Swap(false),
+
+		MissedSlots: c.stats.missed.Swap(0),
+		Pacing:      c.pacing.take(),
+		TargetRate:  c.targetRate(),
+	}
+	st.RTT, st.RTTBuckets = c.rtt.take()
+	if c.cfg.OneWayDelay {
+		st.Upstream, st.Downstream, st.ClockOffset = c.owd.take()
+	}
+	if c.cfg.Mode == internal.ModeEcho {
+		sent, received := c.stats.sent.Load(), c.stats.received.Load()
+		st.InFlight = sent - min(received, sent)
+	}
+	if last := c.stats.lastReceived.Load(); last != 0 && c.end.Load() == 0 {
+		st.Stalled = st.Stalled || time.Since(time.Unix(0, last)) > c.cfg.StallThreshold
+	}
+	return st
+}
+
+// Result returns a summary of the client's run so far.
+func (c *Client) Result() Result {
+	r := Result{
+		Sent:        c.stats.sent.Load(),
+		Received:    c.stats.received.Load(),
+		MaxGap:      time.Duration(c.stats.maxGap.Load()),
+		DialRetries: int(c.dialRetries.Load()),
+
+		MissedSlots:  c.stats.missedTotal.Load(),
+		DroppedSlots: c.stats.dropped.Load(),
+		TargetRate:   c.targetRate(),
+	}
+	r.Stalls, r.Stalled, r.StallList = c.stalls.result()
+	r.RTT, r.RTTP99 = c.rtt.result()
+	r.Pacing, r.PacingP99 = c.pacing.result()
+	if c.cfg.OneWayDelay {
+		r.Upstream, r.Downstream, r.ClockOffset = c.owd.result()
+	}
+	c.rates.mu.Lock()
+	r.MinOpsPerSecond, r.Intervals, r.ClientBound = c.rates.min, c.rates.intervals, c.rates.clientBound
+	c.rates.mu.Unlock()
+
+	start, end := c.start.Load(), c.end.Load()
+	if start == 0 {
+		return r
+	}
+	running := end == 0
+	if running {
+		end = time.Now().UnixNano()
+	}
+	// The current gap counts as well while the client is running, or if it
+	// failed, likely because of it. After a clean shutdown it is only the time
+	// spent draining.
+	if running || c.failed.Load() {
+		gap := time.Duration(end - c.stats.lastReceived.Load())
+		r.MaxGap = max(r.MaxGap, gap)
+		if gap > c.cfg.StallThreshold {
+			r.Stalls++
+			r.Stalled += gap
+			if len(r.StallList) < maxStallList {
+				start := time.Unix(0, c.stats.lastReceived.Load())
+				r.StallList = append(r.StallList, Stall{Start: start, Duration: gap, Phase: c.phase()})
+			}
+		}
+	}
+	if !running && c.cfg.Mode == internal.ModeEcho {
+		r.Lost = r.Sent - min(r.Received, r.Sent)
+	}
+
+	r.ClientToServer = DirectionResult{Sent: r.Sent}
+	r.ServerToClient = DirectionResult{Received: r.Received, MaxGap: r.MaxGap}
+	if c.cfg.Mode == internal.ModeEcho {
+		// The server received every request it replied to.
+		r.ClientToServer.Received = r.Received
+	}
+	if c.cfg.OneWayDelay {
+		r.ClientToServer.MaxGap = c.owd.upGap()
+	}
+	r.Duration = time.Duration(end - start)
+	r.Phases = c.phaseResults(time.Unix(0, start), time.Unix(0, end))
+
+	return r
+}
+
+// writer sends requests paced Interval apart, or following schedule in rate
+// mode if set.
+func (c *Client) writer(ctx context.Context, cancel context.CancelFunc, conn net.Conn, payload *internal.Payload, schedule *internal.Schedule) func() error {
+	return func() error {
+		// Stop the reader when the writer is done, or the ErrGroup will wait forever.
+		defer cancel()
+
+		pacer := internal.NewPacer(c.cfg.Interval)
+
+		// Lock the goroutine to the current OS thread to prevent the runtime from
+		// migrating and interrupting it as often. We're manually calling nanosleep,
+		// bypassing the runtime's scheduler, to get somewhat accurate sleep
+		// behaviour.
+		runtime.LockOSThread()
+		if err := c.cfg.Scheduling.ApplyThread(); err != nil {
+			return err
+		}
+
+		sched := slog.Group("sched", internal.SchedAttrs()...)
+		if schedule != nil {
+			c.log.Info("Sending requests", "rate", c.cfg.Rate, "arrival", c.cfg.Arrival, "timeout", c.cfg.Timeout, sched)
+		} else {
+			c.log.Info("Sending requests", "interval", c.cfg.Interval, "timeout", c.cfg.Timeout, sched)
+		}
+
+		request := make([]byte, internal.MsgSize)
+		for seq := uint64(0); ; seq++ {
+			// Immediately stop producing packets when the client is shutting down.
+			select {
+			case <-ctx.Done():
+				c.log.Info("Writer shutting down")
+				if c.cfg.Mode == internal.ModeDuplex || c.cfg.HalfClose {
+					// Let the server know our stream ended. It closes the connection in
+					// response, which stops the reader.
+					c.log.Info("Half-closing the connection", internal.LogSeq, seq)
+					return withKind(KindWrite, internal.CloseWrite(conn))
+				}
+				return nil
+			default:
+			}
+
+			var due time.Time
+			if schedule != nil {
+				slot := schedule.Wait()
+				due = slot.Intended
+				c.stats.missed.Add(uint64(slot.Missed))
+				c.stats.missedSecond.Add(uint64(slot.Missed))
+				c.stats.missedTotal.Add(uint64(slot.Missed))
+				c.stats.dropped.Add(uint64(slot.Dropped))
+			} else {
+				pacer.Start()
+				due = time.Now()
+			}
+
+			if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+				return withKind(KindWrite, fmt.Errorf("set write deadline: %w", err))
+			}
+
+			payload.Encode(request, seq)
+			// Round-trip times count from when the request was due, including
+			// any delay in sending it.
+			sent := time.Now()
+			c.sendTimes.put(seq, due, sent)
+			n, err := conn.Write(request)
+			c.pacing.add(sent, time.Since(sent))
+			if err != nil {
+				return writeError(fmt.Errorf("conn write: %w", err))
+			}
+			if n != len(request) {
+				return withKind(KindWrite, fmt.Errorf("short write: %d", n))
+			}
+
+			c.send()
+
+			if schedule == nil {
+				pacer.Wait()
+			}
+		}
+	}
+}
+
+func (c *Client) reader(ctx context.Context, cancel context.CancelFunc, conn net.Conn, payload *internal.Payload) func() error {
+	return func() error {
+		// Stop the reader when the writer is done, or the ErrGroup will wait forever.
+		defer cancel()
+
+		last := time.Now()
+		size := internal.MsgSize
+		if c.cfg.OneWayDelay {
+			size += internal.StampSize
+		}
+		reply := make([]byte, size)
+		for seq := uint64(0); ; {
+			if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+				return withKind(KindRead, fmt.Errorf("set read deadline: %w", err))
+			}
+
+			_, err := io.ReadFull(conn, reply)
+			// Allow the reader to drain replies before shutting down instead of
+			// closing the connection immediately. This reduces the chance of the
+			// server seeing a connection reset, which causes red herrings in the
+			// server logs when finding potential conn disruptions.
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				// Check if the deadline was exceeded as a consequence of shutting down.
+				// Require the reader to be fully caught up at this point. When
+				// half-closing, wait for the server to close the connection instead.
+				if c.drained(ctx) {
+					c.log.Info("Reader shutting down")
+					return nil
+				}
+
+				// Retry while the last reply was received within the timeout.
+				if time.Since(last) <= c.cfg.Timeout {
+					continue
+				}
+
+				if status := c.icmpStatus(); status != "" {
+					return withKind(KindTimeout, fmt.Errorf("no reply received within %v timeout (%s): %w", c.cfg.Timeout, status, err))
+				}
+				return withKind(KindTimeout, fmt.Errorf("no reply received within %v timeout: %w", c.cfg.Timeout, err))
+			}
+			if errors.Is(err, io.EOF) {
+				if !c.cfg.HalfClose {
+					c.log.Info("Server closed the connection")
+					return nil
+				}
+				// The server closes its side once it echoed everything up to our
+				// half-close. Every reply must have made it through by then.
+				if sent := c.stats.sent.Load(); seq != sent {
+					return withKind(KindClosed, fmt.Errorf("server closed the connection after %d of %d replies: %w", seq, sent, err))
+				}
+				c.log.Info("Server closed the connection after replying to all requests", internal.LogSeq, seq)
+				return nil
+			}
+			if err != nil {
+				return readError(fmt.Errorf("read reply: %w", err))
+			}
+
+			if err := payload.Verify(reply, seq); err != nil {
+				if !c.cfg.OneWayDelay && stampedReply(reply, seq, time.Now()) {
+					return withKind(KindUnknown, ErrStamped)
+				}
+				return withKind(KindCorrupt, fmt.Errorf("invalid reply: %w", err))
+			}
+
+			last = time.Now()
+			if seq == 0 && c.cfg.OneWayDelay {
+				if received, replied := internal.Stamps(reply); !plausibleStamps(received, replied, last) {
+					return withKind(KindUnknown, ErrNotStamped)
+				}
+			}
+			var delays *oneWay
+			if sent, ok := c.sendTimes.sent(seq); ok && c.cfg.OneWayDelay {
+				received, replied := internal.Stamps(reply)
+				d := c.owd.add(sent, received, replied, last)
+				delays = &d
+			}
+			c.receive(c.sendTimes.rtt(seq, last), delays)
+			seq++
+
+			// Check if we're shutting down and reader fully caught up to the writer,
+			// for a fast exit.
+			if c.drained(ctx) {
+				c.log.Info("Reader shutting down")
+				return nil
+			}
+		}
+	}
+}
+
+// ErrNotStamped is returned with [Config.OneWayDelay] when the server doesn't
+// stamp its replies. Like other configuration errors, it is of [KindUnknown].
+var ErrNotStamped = errors.New("server doesn't stamp replies, run it with stamping enabled or don't measure one-way delays")
+
+// ErrStamped is returned without [Config.OneWayDelay] when the server stamps
+// its replies. Like other configuration errors, it is of [KindUnknown].
+var ErrStamped = errors.New("server sends stamped replies, pass --one-way-delay to measure one-way delays or run it without stamping")
+
+// maxClockOffset is the largest offset between the clocks of client and
+// server considered plausible when checking that replies are stamped.
+const maxClockOffset = 24 * time.Hour
+
+// plausibleStamps returns whether the timestamps of the first reply, received
+// at the given time, can have been written by a stamping server. Without
+// stamps, they hold the next reply's sequence number and payload instead,
+// which make for timestamps decades off.
+func plausibleStamps(received, replied, now time.Time) bool {
+	return !replied.Before(received) && now.Sub(received).Abs() < maxClockOffset
+}
+
+// stampedReply returns whether reply, which failed to verify as the reply to
+// request seq, holds the timestamps the server appended to the first reply
+// instead. Only then is a stamping server mistaken for corrupting replies.
+func stampedReply(reply []byte, seq uint64, now time.Time) bool {
+	if seq != 1 {
+		return false
+	}
+	buf := make([]byte, internal.MsgSize+internal.StampSize)
+	copy(buf[internal.MsgSize:], reply)
+	received, replied := internal.Stamps(buf)
+	return plausibleStamps(received, replied, now)
+}
+
+// drained returns true if the client is shutting down and a reply was
+// received for every request. Always false when half-closing, as the reader
+// waits for the server to close the connection then.
+func (c *Client) drained(ctx context.Context) bool {
+	if ctx.Err() == nil || c.cfg.HalfClose {
+		return false
+	}
+	return c.stats.sent.Load() == c.stats.received.Load()
+}
+
+// streamReader validates the server's stream in duplex mode.
+func (c *Client) streamReader(ctx context.Context, cancel context.CancelFunc, conn net.Conn) func() error {
+	return func() error {
+		// Stop the writer when the server's stream ends.
+		defer cancel()
+
+		r := internal.StreamReader{
+			Conn:      conn,
+			Direction: internal.DirectionServerToClient,
+			Timeout:   c.cfg.Timeout,
+			Log:       c.log,
+			Received:  func() { c.receive(0, nil) },
+		}
+		return readError(r.Run(ctx))
+	}
+}
@@ -0,0 +1,620 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/phase"
+	"github.com/cilium/test-connection-disruption/internal/server"
+	"github.com/cilium/test-connection-disruption/internal/testutil"
+)
+
+// startServer runs a server on loopback until the returned function is called
+// or the test ends.
+func startServer(t *testing.T, cfg server.Config) (string, func()) {
+	t.Helper()
+
+	if cfg.Transport == "" {
+		cfg.Transport = internal.TransportTCP
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = internal.ModeEcho
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Millisecond
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	cfg.Log = testutil.Logger(t)
+
+	srv, err := server.Listen(cfg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx) }()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Error("serve:", err)
+			}
+		})
+	}
+	t.Cleanup(stop)
+
+	return srv.Addr().String(), stop
+}
+
+// startFakeServer accepts connections on loopback and passes them to handle.
+func startFakeServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func newTestClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+
+	if cfg.Transport == "" {
+		cfg.Transport = internal.TransportTCP
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = internal.ModeEcho
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Millisecond
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	cfg.Log = testutil.Logger(t)
+
+	return New(cfg)
+}
+
+// runFor runs the client and shuts it down after d.
+func runFor(c *Client, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return c.Run(ctx)
+}
+
+func TestEcho(t *testing.T) {
+	for _, mode := range internal.Modes {
+		t.Run(mode, func(t *testing.T) {
+			addr, _ := startServer(t, server.Config{Mode: mode})
+			c := newTestClient(t, Config{Addr: addr, Mode: mode})
+
+			if err := runFor(c, 500*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.stats.received.Load() == 0 {
+				t.Fatal("no messages received")
+			}
+
+			// Only replies tell the client its messages arrived.
+			res := c.Result()
+			want := DirectionResult{Sent: res.Sent}
+			if mode == internal.ModeEcho {
+				want.Received = res.Received
+			}
+			if res.ClientToServer != want {
+				t.Errorf("expected %+v from client to server, got %+v", want, res.ClientToServer)
+			}
+			want = DirectionResult{Received: res.Received, MaxGap: res.MaxGap}
+			if res.ServerToClient != want {
+				t.Errorf("expected %+v from server to client, got %+v", want, res.ServerToClient)
+			}
+		})
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	for _, mode := range []string{internal.ModeEcho, internal.ModeDuplex} {
+		t.Run(mode, func(t *testing.T) {
+			addr, _ := startServer(t, server.Config{Mode: mode, HalfClose: true})
+			c := newTestClient(t, Config{Addr: addr, Mode: mode, HalfClose: true})
+
+			if err := runFor(c, 500*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	addr, stop := startServer(t, server.Config{})
+	c := newTestClient(t, Config{Addr: addr})
+
+	time.AfterFunc(300*time.Millisecond, stop)
+
+	// The client keeps running until the server goes away.
+	if err := runFor(c, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// Swallow all requests without replying.
+	addr := startFakeServer(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+	var stalls []Stall
+	c := newTestClient(t, Config{Addr: addr, Timeout: 300 * time.Millisecond, OnStall: func(s Stall) { stalls = append(stalls, s) }})
+
+	start := time.Now()
+	err := runFor(c, 10*time.Second)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || KindOf(err) != KindTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("timeout detected after %s", elapsed)
+	}
+	if len(stalls) != 1 || stalls[0].Duration < 300*time.Millisecond {
+		t.Fatalf("expected the stall the client failed in, got %+v", stalls)
+	}
+}
+
+func TestInvalidReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		mangle func([]byte)
+		err    string
+	}{
+		{
+			name:   "payload",
+			mangle: func(msg []byte) { msg[internal.MsgSize-1]++ },
+			err:    "invalid payload",
+		},
+		{
+			name:   "sequence",
+			mangle: func(msg []byte) { msg[7] += 2 },
+			err:    "unexpected sequence number",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Echo a few messages correctly before starting to mangle them.
+			addr := startFakeServer(t, func(conn net.Conn) {
+				buf := make([]byte, internal.MsgSize)
+				for n := 0; ; n++ {
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					if n >= 5 {
+						tt.mangle(buf)
+					}
+					if _, err := conn.Write(buf); err != nil {
+						return
+					}
+				}
+			})
+			c := newTestClient(t, Config{Addr: addr})
+
+			err := runFor(c, 10*time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+			if kind := KindOf(err); kind != KindCorrupt {
+				t.Fatalf("expected corrupt failure, got %s", kind)
+			}
+			if got := c.stats.received.Load(); got != 5 {
+				t.Fatalf("expected 5 valid replies, got %d", got)
+			}
+		})
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	// Echo every request with a delay, so there are replies in flight when the
+	// client shuts down.
+	addr := startFakeServer(t, func(conn net.Conn) {
+		type delayed struct {
+			at  time.Time
+			msg []byte
+		}
+		queue := make(chan delayed, 1024)
+		go func() {
+			for d := range queue {
+				time.Sleep(time.Until(d.at))
+				conn.Write(d.msg)
+			}
+		}()
+		defer close(queue)
+
+		for {
+			msg := make([]byte, internal.MsgSize)
+			if _, err := io.ReadFull(conn, msg); err != nil {
+				return
+			}
+			queue <- delayed{time.Now().Add(200 * time.Millisecond), msg}
+		}
+	})
+	c := newTestClient(t, Config{Addr: addr})
+
+	if err := runFor(c, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, received := c.stats.sent.Load(), c.stats.received.Load()
+	if sent == 0 || sent != received {
+		t.Fatalf("expected all replies to be drained, sent %d, received %d", sent, received)
+	}
+}
+
+func TestPacing(t *testing.T) {
+	const (
+		interval = 20 * time.Millisecond
+		duration = time.Second
+	)
+
+	addr, _ := startServer(t, server.Config{})
+	c := newTestClient(t, Config{Addr: addr, Interval: interval})
+
+	if err := runFor(c, duration); err != nil {
+		t.Fatal(err)
+	}
+
+	// Allow for some slack on loaded machines, but catch gross deviations like
+	// the runtime coalescing sleeps.
+	want := uint64(duration / interval)
+	if got := c.stats.sent.Load(); got < want*8/10 || got > want*12/10 {
+		t.Fatalf("expected about %d requests at %s interval, sent %d", want, interval, got)
+	}
+
+	res := c.Result()
+	if rate := res.Pacing.Rate(); rate < res.TargetRate*8/10 || rate > res.TargetRate*12/10 {
+		t.Fatalf("expected a send rate of about %g, got %g", res.TargetRate, rate)
+	}
+	if res.Pacing.Jitter() > interval/2 {
+		t.Fatalf("expected a jitter below %s, got %s", interval/2, res.Pacing.Jitter())
+	}
+}
+
+func TestClientBound(t *testing.T) {
+	c := New(Config{Interval: 10 * time.Millisecond})
+	rate := New(Config{Rate: 100})
+
+	for _, tt := range []struct {
+		name   string
+		c      *Client
+		second SendIntervals
+		missed uint64
+		want   bool
+	}{
+		{"on target", c, SendIntervals{Count: 100, Sum: time.Second}, 0, false},
+		{"throttled", c, SendIntervals{Count: 50, Sum: time.Second}, 0, true},
+		{"blocked writes", c, SendIntervals{Count: 50, Sum: time.Second, Blocked: 400 * time.Millisecond}, 0, false},
+		{"nothing sent", c, SendIntervals{}, 0, false},
+		{"rate on target", rate, SendIntervals{Count: 90, Sum: time.Second}, 0, false},
+		{"rate missed slots", rate, SendIntervals{Count: 100, Sum: time.Second}, 30, true},
+	} {
+		if got := tt.c.clientBound(tt.second, tt.missed); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	const (
+		rate     = 100
+		duration = time.Second
+	)
+
+	for _, arrival := range internal.Arrivals {
+		t.Run(arrival, func(t *testing.T) {
+			addr, _ := startServer(t, server.Config{})
+			c := newTestClient(t, Config{Addr: addr, Rate: rate, Arrival: arrival})
+
+			if err := runFor(c, duration); err != nil {
+				t.Fatal(err)
+			}
+
+			// Poisson arrivals vary more, by about 10% at this rate.
+			want := uint64(rate * duration.Seconds())
+			if got := c.stats.sent.Load(); got < want*7/10 || got > want*13/10 {
+				t.Fatalf("expected about %d requests at %d/s, sent %d", want, rate, got)
+			}
+			if res := c.Result(); res.DroppedSlots > 0 {
+				t.Fatalf("expected no dropped slots, got %d", res.DroppedSlots)
+			}
+		})
+	}
+}
+
+func TestOneWayDelay(t *testing.T) {
+	addr, _ := startServer(t, server.Config{Stamp: true})
+	c := newTestClient(t, Config{Addr: addr, OneWayDelay: true})
+
+	if err := runFor(c, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client and server share the clock.
+	res := c.Result()
+	if res.Upstream.Count == 0 || res.Upstream.Count != res.Downstream.Count {
+		t.Fatalf("expected one-way delays of every reply, got %d up and %d down", res.Upstream.Count, res.Downstream.Count)
+	}
+	if res.ClockOffset.Abs() > time.Millisecond {
+		t.Fatalf("expected no clock offset, got %s", res.ClockOffset)
+	}
+	if res.Upstream.Max > res.RTT.Max || res.Downstream.Max > res.RTT.Max {
+		t.Fatalf("one-way delays up to %s and %s exceed the round-trip time of %s", res.Upstream.Max, res.Downstream.Max, res.RTT.Max)
+	}
+	if res.ClientToServer.MaxGap < c.cfg.Interval/2 || res.ClientToServer.MaxGap > res.MaxGap+res.RTT.Max {
+		t.Fatalf("expected a gap from client to server around the %s interval, got %s", c.cfg.Interval, res.ClientToServer.MaxGap)
+	}
+}
+
+func TestOneWayDelayStamped(t *testing.T) {
+	addr, _ := startServer(t, server.Config{Stamp: true})
+	c := newTestClient(t, Config{Addr: addr})
+
+	err := runFor(c, 5*time.Second)
+	if !errors.Is(err, ErrStamped) || KindOf(err) != KindUnknown {
+		t.Fatalf("expected %v, got %v", ErrStamped, err)
+	}
+}
+
+func TestOneWayDelayUnstamped(t *testing.T) {
+	addr, _ := startServer(t, server.Config{})
+	c := newTestClient(t, Config{Addr: addr, OneWayDelay: true})
+
+	err := runFor(c, 5*time.Second)
+	if !errors.Is(err, ErrNotStamped) || KindOf(err) != KindUnknown {
+		t.Fatalf("expected %v, got %v", ErrNotStamped, err)
+	}
+
+	for _, mode := range []string{internal.ModeReverse, internal.ModeDuplex} {
+		c := newTestClient(t, Config{Addr: addr, Mode: mode, OneWayDelay: true})
+		if err := runFor(c, 5*time.Second); err == nil || !strings.Contains(err.Error(), "one-way delays") {
+			t.Errorf("%s mode: expected configuration error, got %v", mode, err)
+		}
+	}
+}
+
+func TestOneWayStats(t *testing.T) {
+	// The server's clock is a second ahead, and a reply takes 1ms each way
+	// plus 100µs in the server.
+	const offset = time.Second
+	var s oneWayStats
+	start := time.Now()
+	reply := func(t0 time.Time, up, down time.Duration) oneWay {
+		t1 := t0.Add(up + offset)
+		t2 := t1.Add(100 * time.Microsecond)
+		return s.add(t0, t1, t2, t2.Add(down-offset))
+	}
+	for i := range 10 {
+		reply(start.Add(time.Duration(i)*10*time.Millisecond), time.Millisecond, time.Millisecond)
+	}
+	if _, _, got := s.result(); got != offset {
+		t.Fatalf("expected a clock offset of %s, got %s", offset, got)
+	}
+
+	// Queueing in one direction doesn't skew the estimate.
+	d := reply(start.Add(100*time.Millisecond), 500*time.Millisecond, time.Millisecond)
+	if d.up != 500*time.Millisecond || d.down != time.Millisecond {
+		t.Fatalf("unexpected delays %+v", d)
+	}
+	if got := d.direction(50 * time.Millisecond); got != internal.DirectionClientToServer {
+		t.Fatalf("expected a stall from client to server, got %s", got)
+	}
+	if got := s.upGap(); got != 509*time.Millisecond {
+		t.Fatalf("expected a gap of 509ms from client to server, got %s", got)
+	}
+	d = reply(start.Add(200*time.Millisecond), time.Millisecond, 500*time.Millisecond)
+	if got := d.direction(50 * time.Millisecond); got != internal.DirectionServerToClient {
+		t.Fatalf("expected a stall from server to client, got %s", got)
+	}
+	d = reply(start.Add(300*time.Millisecond), 200*time.Millisecond, 300*time.Millisecond)
+	if got := d.direction(50 * time.Millisecond); got != DirectionBoth {
+		t.Fatalf("expected a stall in both directions, got %s", got)
+	}
+
+	// Old samples expire, following the clocks drifting apart.
+	later := start.Add(2 * offsetWindow)
+	s.add(later, later.Add(2*offset+time.Millisecond), later.Add(2*offset+time.Millisecond), later.Add(2*time.Millisecond))
+	s.add(later.Add(offsetWindow), later.Add(offsetWindow+2*offset+time.Millisecond), later.Add(offsetWindow+2*offset+time.Millisecond), later.Add(offsetWindow+2*time.Millisecond))
+	if _, _, got := s.result(); got != 2*offset {
+		t.Fatalf("expected the clock offset to follow to %s, got %s", 2*offset, got)
+	}
+}
+
+func TestPhases(t *testing.T) {
+	addr, _ := startServer(t, server.Config{})
+	phases := phase.NewTracker("before")
+	c := newTestClient(t, Config{Addr: addr, Phases: phases})
+
+	time.AfterFunc(200*time.Millisecond, func() { phases.Set("during") })
+	time.AfterFunc(400*time.Millisecond, func() { phases.Set("after") })
+
+	if err := runFor(c, 600*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	res := c.Result()
+	var names []string
+	for _, p := range res.Phases {
+		names = append(names, p.Name)
+		if p.Received == 0 {
+			t.Errorf("phase %s: no messages received", p.Name)
+		}
+		if p.RTT.Count == 0 || p.RTT.Min <= 0 || p.RTT.Max < p.RTT.Min {
+			t.Errorf("phase %s: bad round-trip times %+v", p.Name, p.RTT)
+		}
+		if p.Duration < 100*time.Millisecond || p.Duration > 300*time.Millisecond {
+			t.Errorf("phase %s: duration %s", p.Name, p.Duration)
+		}
+	}
+	if got := strings.Join(names, ","); got != "before,during,after" {
+		t.Fatalf("phases %s", got)
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	// Pause replies for a while, without reaching the timeout. The pause covers
+	// a full second so that the backlog received after it doesn't make up for
+	// the missed replies.
+	var paused atomic.Bool
+	addr := startFakeServer(t, func(conn net.Conn) {
+		buf := make([]byte, internal.MsgSize)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			for paused.Load() {
+				time.Sleep(time.Millisecond)
+			}
+			if _, err := conn.Write(buf); err != nil {
+				return
+			}
+		}
+	})
+
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+	}{
+		{"max_gap", Thresholds{MaxGap: 200 * time.Millisecond}},
+		{"max_stalled", Thresholds{MaxStalled: 200 * time.Millisecond}},
+		{"max_p99_rtt", Thresholds{MaxP99RTT: 200 * time.Millisecond}},
+		{"min_ops_per_second", Thresholds{MinOpsPerSecond: 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paused.Store(false)
+			time.AfterFunc(1200*time.Millisecond, func() { paused.Store(true) })
+			time.AfterFunc(3500*time.Millisecond, func() { paused.Store(false) })
+
+			c := newTestClient(t, Config{Addr: addr, Timeout: 5 * time.Second, Thresholds: tt.thresholds})
+			start := time.Now()
+			err := runFor(c, 10*time.Second)
+
+			var te *ThresholdError
+			if !errors.As(err, &te) || te.Threshold != tt.name || KindOf(err) != KindThreshold {
+				t.Fatalf("expected %s violation, got %v", tt.name, err)
+			}
+			// Violations stop the client without waiting for it to end.
+			if elapsed := time.Since(start); elapsed > 8*time.Second {
+				t.Fatalf("violation detected after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestThresholdsPass(t *testing.T) {
+	addr, _ := startServer(t, server.Config{})
+	loss, dialRetries := 0.0, 0
+	c := newTestClient(t, Config{Addr: addr, Thresholds: Thresholds{
+		MaxGap:          time.Second,
+		MaxStalled:      time.Second,
+		MaxLoss:         &loss,
+		MaxP99RTT:       time.Second,
+		MinOpsPerSecond: 10,
+		MaxDialRetries:  &dialRetries,
+	}})
+
+	if err := runFor(c, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.Result(); res.Intervals != 1 || res.RTT.Count == 0 || res.RTTP99 <= 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := newTestClient(t, Config{Addr: addr})
+	if err := runFor(c, 10*time.Second); KindOf(err) != KindDial {
+		t.Fatalf("expected dial failure, got %v", err)
+	}
+}
+
+func TestSchedulingBeforeDial(t *testing.T) {
+	var dialed atomic.Bool
+	addr := startFakeServer(t, func(net.Conn) { dialed.Store(true) })
+
+	// No machine has the last CPU of the affinity mask.
+	c := newTestClient(t, Config{Addr: addr, Scheduling: internal.Scheduling{CPUs: []int{1023}}})
+	err := runFor(c, 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "CPU affinity") {
+		t.Fatalf("expected scheduling error, got %v", err)
+	}
+	if dialed.Load() {
+		t.Fatal("client connected before applying the scheduling settings")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	timeout := &Error{Kind: KindTimeout, Err: errors.New("timeout")}
+	dial := fmt.Errorf("b: %w", &Error{Kind: KindDial, Err: errors.New("dial")})
+	threshold := &ThresholdError{Threshold: "max_gap"}
+
+	tests := []struct {
+		err  error
+		want Kind
+	}{
+		{errors.New("other"), KindUnknown},
+		{fmt.Errorf("wrapped: %w", timeout), KindTimeout},
+		{threshold, KindThreshold},
+		// The most severe failure of several clients wins.
+		{fmt.Errorf("2 of 3 failed: %w", errors.Join(dial, timeout, threshold)), KindTimeout},
+	}
+	for _, tt := range tests {
+		if got := KindOf(tt.err); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
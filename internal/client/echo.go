@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// echo sends every message received from the server straight back. Used in
+// reverse mode, where the server drives the flow and detects stalls, so echo
+// waits for messages indefinitely.
+func (c *Client) echo(ctx context.Context, conn net.Conn) func() error {
+	return func() error {
+		// Unblock reads when the client is shutting down.
+		stop := context.AfterFunc(ctx, func() {
+			c.log.Info("Echo shutting down")
+			conn.Close()
+		})
+		defer stop()
+
+		c.log.Info("Echoing requests from the server")
+
+		buf := make([]byte, internal.MsgSize)
+		for {
+			_, err := io.ReadFull(conn, buf)
+			if errors.Is(err, net.ErrClosed) && ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				if c.cfg.HalfClose {
+					// Everything the server sent has been echoed at this point. Close
+					// our side to let it verify all replies arrived.
+					c.log.Info("Server half-closed the connection, closing our side")
+					return withKind(KindWrite, internal.CloseWrite(conn))
+				}
+				c.log.Info("Server closed the connection")
+				return nil
+			}
+			if err != nil {
+				return readError(fmt.Errorf("read request: %w", err))
+			}
+
+			c.receive(0, nil)
+
+			if _, err := conn.Write(buf); err != nil {
+				if errors.Is(err, net.ErrClosed) && ctx.Err() != nil {
+					return nil
+				}
+				return writeError(fmt.Errorf("write reply: %w", err))
+			}
+
+			c.send()
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// Kind categorizes the failures of a client, so that they can be triaged
+// automatically. Kinds are ordered by severity.
+type Kind int
+
+const (
+	// KindUnknown is any failure not covered by the other kinds, such as
+	// invalid configuration.
+	KindUnknown Kind = iota
+	// KindDial is a failure to connect to the server, after all attempts.
+	KindDial
+	// KindThreshold is a violated threshold, see [Thresholds].
+	KindThreshold
+	// KindRead is a failure to read from the connection.
+	KindRead
+	// KindWrite is a failure to write to the connection, other than it being
+	// reset.
+	KindWrite
+	// KindClosed is the connection being reset, or closed by the server before
+	// the client received everything it expected.
+	KindClosed
+	// KindTimeout is no message being received within the timeout.
+	KindTimeout
+	// KindCorrupt is a message that arrived with unexpected content.
+	KindCorrupt
+)
+
+var kindNames = map[Kind]string{
+	KindUnknown:   "unknown",
+	KindDial:      "dial",
+	KindThreshold: "threshold",
+	KindRead:      "read",
+	KindWrite:     "write",
+	KindClosed:    "closed",
+	KindTimeout:   "timeout",
+	KindCorrupt:   "corrupt",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// ExitCode returns the exit status for failures of this kind. The codes are
+// stable, unlike the order of kinds.
+func (k Kind) ExitCode() int {
+	switch k {
+	case KindDial:
+		return 10
+	case KindTimeout:
+		return 11
+	case KindCorrupt:
+		return 12
+	case KindClosed:
+		return 13
+	case KindWrite:
+		return 14
+	case KindRead:
+		return 15
+	case KindThreshold:
+		return 16
+	}
+	return 1
+}
+
+// Error is a failure of the client of a known kind.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of err, which may wrap or join several failures,
+// such as those of multiple clients. The most severe kind wins.
+func KindOf(err error) Kind {
+	kind := KindUnknown
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+			return
+		case *Error:
+			kind = max(kind, e.Kind)
+		case *ThresholdError:
+			kind = max(kind, KindThreshold)
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		}
+	}
+	walk(err)
+	return kind
+}
+
+func withKind(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// readError categorizes an error reading from the connection, if any.
+func readError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return withKind(KindTimeout, err)
+	case errors.Is(err, internal.ErrInvalidMessage):
+		return withKind(KindCorrupt, err)
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return withKind(KindClosed, err)
+	}
+	return withKind(KindRead, err)
+}
+
+// writeError categorizes an error writing to the connection, if any.
+func writeError(err error) error {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return withKind(KindClosed, err)
+	}
+	return withKind(KindWrite, err)
+}
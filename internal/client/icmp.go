@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// icmpProber sends ICMP or ICMPv6 echo requests to the host part of the
+// client's target address and counts the replies.
+type icmpProber struct {
+	file *os.File
+	v6   bool
+	// raw is set when the socket is a raw socket instead of an unprivileged ping
+	// socket. Raw sockets see all ICMP traffic of the host, including the IP
+	// header for IPv4, so replies need to be filtered by identifier.
+	raw bool
+	id  uint16
+
+	stats *stats
+	log   *slog.Logger
+}
+
+// newICMPProber opens an ICMP socket connected to the host part of addr. It
+// prefers unprivileged ping sockets and falls back to raw sockets, which
+// require CAP_NET_RAW.
+func newICMPProber(addr string, stats *stats, log *slog.Logger) (*icmpProber, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("split host and port: %w", err)
+	}
+	ip, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", host, err)
+	}
+
+	p := &icmpProber{id: uint16(os.Getpid()), stats: stats, log: log}
+
+	var sa syscall.Sockaddr
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if ip4 := ip.IP.To4(); ip4 != nil {
+		sa = &syscall.SockaddrInet4{Addr: [4]byte(ip4)}
+	} else {
+		p.v6 = true
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		zone, err := zoneID(ip.Zone)
+		if err != nil {
+			return nil, err
+		}
+		sa = &syscall.SockaddrInet6{Addr: [16]byte(ip.IP.To16()), ZoneId: zone}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		p.raw = true
+		fd, err = syscall.Socket(family, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open ICMP socket: %w", err)
+	}
+
+	if err := syscall.Connect(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("connect ICMP socket: %w", err)
+	}
+
+	// Make the socket non-blocking so the runtime poller manages it, which
+	// enables read deadlines on the resulting file.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("set ICMP socket non-blocking: %w", err)
+	}
+	p.file = os.NewFile(uintptr(fd), "icmp")
+
+	return p, nil
+}
+
+// zoneID returns the index of the interface named by an IPv6 zone, as needed
+// to reach link-local addresses. The zone may also be the index itself.
+func zoneID(zone string) (uint32, error) {
+	if zone == "" {
+		return 0, nil
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index), nil
+	}
+	index, err := strconv.ParseUint(zone, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown IPv6 zone %q", zone)
+	}
+	return uint32(index), nil
+}
+
+func (p *icmpProber) Close() error {
+	return p.file.Close()
+}
+
+// run sends an echo request every interval until ctx is cancelled, while
+// counting echo replies in the background.
+func (p *icmpProber) run(ctx context.Context, interval time.Duration) {
+	go p.receive(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var seq uint16
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		seq++
+		if _, err := p.file.Write(p.request(seq)); err != nil {
+			// Sending fails while there's no route to the destination, which is
+			// exactly the kind of disruption we want to see in the stats.
+			continue
+		}
+		p.stats.icmpTx.Add(1)
+		p.stats.icmpSent.Add(1)
+	}
+}
+
+func (p *icmpProber) receive(ctx context.Context) {
+	buf := make([]byte, 1500)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := p.file.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			p.log.Error("Stopping ICMP receiver", "error", fmt.Errorf("set read deadline: %w", err))
+			return
+		}
+
+		n, err := p.file.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Errors like ECONNREFUSED are reported on the socket when ICMP errors
+			// are received. Keep going, the next request might succeed.
+			continue
+		}
+
+		if p.isReply(buf[:n]) {
+			p.stats.icmpRx.Add(1)
+			p.stats.icmpReceived.Add(1)
+			p.stats.icmpLast.Store(time.Now().UnixNano())
+		}
+	}
+}
+
+// request returns an echo request message with the given sequence number.
+func (p *icmpProber) request(seq uint16) []byte {
+	msg := make([]byte, 16)
+	msg[0] = icmpv4EchoRequest
+	if p.v6 {
+		msg[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], p.id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint64(msg[8:], uint64(time.Now().UnixNano()))
+
+	// The kernel fills in the checksum for ICMPv6 as it covers a pseudo-header,
+	// but not for ICMP on raw sockets.
+	if !p.v6 {
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+
+	return msg
+}
+
+func (p *icmpProber) isReply(msg []byte) bool {
+	// Raw IPv4 sockets return the IP header along with the payload.
+	if p.raw && !p.v6 {
+		if len(msg) < 1 || msg[0]>>4 != 4 {
+			return false
+		}
+		ihl := int(msg[0]&0x0f) * 4
+		if ihl < 20 || len(msg) < ihl {
+			return false
+		}
+		msg = msg[ihl:]
+	}
+
+	if len(msg) < 8 {
+		return false
+	}
+
+	want := byte(icmpv4EchoReply)
+	if p.v6 {
+		want = icmpv6EchoReply
+	}
+	if msg[0] != want {
+		return false
+	}
+
+	// Ping sockets rewrite the identifier and only deliver replies belonging to
+	// the socket.
+	return !p.raw || binary.BigEndian.Uint16(msg[4:]) == p.id
+}
+
+// checksum implements the internet checksum from RFC 1071.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// icmpStatus describes the state of the ICMP prober for inclusion in a stall
+// report. Returns an empty string if the prober is disabled.
+func (c *Client) icmpStatus() string {
+	if c.cfg.ICMPInterval == 0 {
+		return ""
+	}
+
+	last := c.stats.icmpLast.Load()
+	if last == 0 {
+		return "no ICMP echo reply received yet"
+	}
+	return fmt.Sprintf("last ICMP echo reply received %s ago", time.Since(time.Unix(0, last)).Round(time.Millisecond))
+}
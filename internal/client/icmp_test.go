@@ -0,0 +1,77 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want uint16
+	}{
+		{"empty", nil, 0xffff},
+		// Echo request with identifier 1 and sequence 1, checksum zeroed.
+		{"echo request", []byte{8, 0, 0, 0, 0, 1, 0, 1}, 0xf7fd},
+		// The same request with its checksum filled in sums to zero.
+		{"verify", []byte{8, 0, 0xf7, 0xfd, 0, 1, 0, 1}, 0},
+		// The example from RFC 1071 section 3, which carries twice.
+		{"rfc 1071", []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}, ^uint16(0xddf2)},
+		{"odd length", []byte{8, 0, 0, 0, 0, 1, 0, 1, 0xab}, ^uint16(0x0802 + 0xab00)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checksum(tt.b); got != tt.want {
+				t.Errorf("checksum = %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICMPIsReply(t *testing.T) {
+	// An IPv4 header without options, as returned by raw sockets.
+	ipv4 := []byte{0x45, 0, 0, 28, 0, 0, 0, 0, 64, 1, 0, 0, 127, 0, 0, 1, 127, 0, 0, 1}
+	v4Reply := []byte{icmpv4EchoReply, 0, 0xff, 0xfd, 0x12, 0x34, 0, 1}
+	v6Reply := []byte{icmpv6EchoReply, 0, 0, 0, 0x12, 0x34, 0, 1}
+
+	for _, tt := range []struct {
+		name string
+		p    icmpProber
+		msg  []byte
+		want bool
+	}{
+		{"ping v4", icmpProber{id: 0x1234}, v4Reply, true},
+		{"ping v4 other id", icmpProber{id: 1}, v4Reply, true},
+		{"ping v4 request", icmpProber{}, []byte{icmpv4EchoRequest, 0, 0, 0, 0, 1, 0, 1}, false},
+		{"ping v4 short", icmpProber{}, v4Reply[:7], false},
+		{"ping v6", icmpProber{v6: true}, v6Reply, true},
+		{"ping v6 given v4", icmpProber{v6: true}, v4Reply, false},
+		{"raw v4", icmpProber{raw: true, id: 0x1234}, append(ipv4, v4Reply...), true},
+		{"raw v4 other id", icmpProber{raw: true, id: 1}, append(ipv4, v4Reply...), false},
+		{"raw v4 no header", icmpProber{raw: true, id: 0x1234}, v4Reply, false},
+		{"raw v4 truncated header", icmpProber{raw: true}, ipv4[:10], false},
+		{"raw v4 empty", icmpProber{raw: true}, nil, false},
+		{"raw v6", icmpProber{raw: true, v6: true, id: 0x1234}, v6Reply, true},
+		{"raw v6 other id", icmpProber{raw: true, v6: true, id: 1}, v6Reply, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.isReply(tt.msg); got != tt.want {
+				t.Errorf("isReply = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneID(t *testing.T) {
+	if id, err := zoneID(""); err != nil || id != 0 {
+		t.Errorf(`zoneID("") = %d, %v, want 0`, id, err)
+	}
+	if id, err := zoneID("lo"); err == nil && id == 0 {
+		t.Errorf(`zoneID("lo") = 0`)
+	}
+	if id, err := zoneID("7"); err != nil || id != 7 {
+		t.Errorf(`zoneID("7") = %d, %v, want 7`, id, err)
+	}
+	if _, err := zoneID("no-such-interface"); err == nil {
+		t.Error(`zoneID("no-such-interface") succeeded`)
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// DirectionBoth marks a stall that occurred in both directions, see
+// [Stall.Direction].
+const DirectionBoth = "both"
+
+// offsetWindow is how long a sample is used to estimate the clock offset
+// between client and server, bounding the error from the clocks drifting
+// apart.
+const offsetWindow = 5 * time.Second
+
+// oneWay holds the delays of a stamped reply in each direction.
+type oneWay struct {
+	up, down time.Duration
+}
+
+// direction returns the direction a stall ended by this reply occurred in:
+// the one it was delayed in by more than threshold, or the one it was
+// delayed in the most.
+func (d oneWay) direction(threshold time.Duration) string {
+	switch {
+	case d.up > threshold && d.down > threshold:
+		return DirectionBoth
+	case d.up >= d.down:
+		return internal.DirectionClientToServer
+	default:
+		return internal.DirectionServerToClient
+	}
+}
+
+// offsetSample is the clock offset of the server measured from one stamped
+// reply, and the round-trip delay it was measured with.
+type offsetSample struct {
+	offset, delay time.Duration
+	valid         bool
+}
+
+// oneWayStats records the one-way delays of a client, from the timestamps
+// the server stamps replies with. Like NTP, it estimates the offset of the
+// server's clock from the sample with the lowest round-trip delay among the
+// recent ones, which is the least skewed by queueing in either direction.
+type oneWayStats struct {
+	mu sync.Mutex
+	// cur holds the best sample since curStart, prev the one of the window
+	// before.
+	cur, prev offsetSample
+	curStart  time.Time
+
+	up, down RTTStats
+	// upInterval and downInterval hold the delays since the last call to
+	// take.
+	upInterval, downInterval RTTStats
+
+	// lastReceived is when the server received the last request, and maxUpGap
+	// the longest gap between two requests arriving there, by its clock.
+	lastReceived time.Time
+	maxUpGap     time.Duration
+}
+
+// add records a stamped reply to a request sent at t0, received by the
+// server at t1 and sent back at t2, and received by the client at t3, and
+// returns its delay in each direction.
+func (s *oneWayStats) add(t0, t1, t2, t3 time.Time) oneWay {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := offsetSample{
+		offset: (t1.Sub(t0) + t2.Sub(t3)) / 2,
+		delay:  t3.Sub(t0) - t2.Sub(t1),
+		valid:  true,
+	}
+	if t3.Sub(s.curStart) > offsetWindow {
+		s.prev, s.cur, s.curStart = s.cur, sample, t3
+	} else if sample.delay < s.cur.delay {
+		s.cur = sample
+	}
+
+	offset := s.offset()
+	d := oneWay{
+		up:   max(t1.Sub(t0)-offset, 0),
+		down: max(t3.Sub(t2)+offset, 0),
+	}
+	s.up.add(d.up)
+	s.down.add(d.down)
+	s.upInterval.add(d.up)
+	s.downInterval.add(d.down)
+
+	if !s.lastReceived.IsZero() {
+		s.maxUpGap = max(s.maxUpGap, t1.Sub(s.lastReceived))
+	}
+	s.lastReceived = t1
+	return d
+}
+
+// offset returns the estimated offset of the server's clock. Must be called
+// with mu held.
+func (s *oneWayStats) offset() time.Duration {
+	if s.prev.valid && s.prev.delay < s.cur.delay {
+		return s.prev.offset
+	}
+	return s.cur.offset
+}
+
+// take returns the delays in each direction since the last call, and the
+// current clock offset.
+func (s *oneWayStats) take() (up, down RTTStats, offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up, down = s.upInterval, s.downInterval
+	s.upInterval, s.downInterval = RTTStats{}, RTTStats{}
+	return up, down, s.offset()
+}
+
+// upGap returns the longest gap between two requests arriving at the
+// server.
+func (s *oneWayStats) upGap() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxUpGap
+}
+
+// result returns the delays in each direction and the current clock offset.
+func (s *oneWayStats) result() (up, down RTTStats, offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.up, s.down, s.offset()
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// SendIntervals summarizes the intervals between two requests sent, to tell
+// how closely the client kept to its target rate.
+type SendIntervals struct {
+	Count         uint64
+	Min, Max, Sum time.Duration
+	// Blocked is the time spent writing requests to the socket, which grows
+	// when the network doesn't keep up rather than the client.
+	Blocked time.Duration
+
+	// Sum of the squared intervals in seconds, for the jitter.
+	sumSquares float64
+}
+
+func (s *SendIntervals) add(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	s.Max = max(s.Max, d)
+	s.Sum += d
+	s.sumSquares += d.Seconds() * d.Seconds()
+	s.Count++
+}
+
+// Avg returns the average interval, or 0 without samples.
+func (s SendIntervals) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Rate returns the effective number of requests sent per second, or 0
+// without samples.
+func (s SendIntervals) Rate() float64 {
+	if s.Sum <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Sum.Seconds()
+}
+
+// Jitter returns the standard deviation of the intervals.
+func (s SendIntervals) Jitter() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	avg := s.Sum.Seconds() / float64(s.Count)
+	variance := max(s.sumSquares/float64(s.Count)-avg*avg, 0)
+	return time.Duration(math.Sqrt(variance) * float64(time.Second))
+}
+
+// pacingStats records the intervals between requests sent by the writer.
+type pacingStats struct {
+	mu    sync.Mutex
+	last  time.Time
+	stats SendIntervals
+	hist  internal.Histogram
+	// interval holds the intervals since the last call to take, and second
+	// those since the last call to takeSecond by the monitor.
+	interval, second SendIntervals
+}
+
+// add records a request sent at the given time, whose write blocked for the
+// given duration.
+func (s *pacingStats) add(sent time.Time, blocked time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Blocked += blocked
+	s.interval.Blocked += blocked
+	s.second.Blocked += blocked
+	last := s.last
+	s.last = sent
+	if last.IsZero() {
+		return
+	}
+	d := sent.Sub(last)
+	s.stats.add(d)
+	s.hist.Add(d)
+	s.interval.add(d)
+	s.second.add(d)
+}
+
+// take returns the intervals since the last call.
+func (s *pacingStats) take() SendIntervals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := s.interval
+	s.interval = SendIntervals{}
+	return interval
+}
+
+// takeSecond returns the intervals since the last call, for the monitor.
+func (s *pacingStats) takeSecond() SendIntervals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	second := s.second
+	s.second = SendIntervals{}
+	return second
+}
+
+// result returns the summary and 99th percentile of the intervals.
+func (s *pacingStats) result() (SendIntervals, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats, s.hist.Quantile(0.99)
+}
+
+// targetRate returns the number of requests per second the client aims for,
+// or 0 if it doesn't send any of its own.
+func (c *Client) targetRate() float64 {
+	if c.cfg.Mode == internal.ModeReverse {
+		return 0
+	}
+	if c.cfg.Rate > 0 {
+		return c.cfg.Rate
+	}
+	return float64(time.Second) / float64(c.cfg.Interval)
+}
+
+// clientBound returns whether the client itself rather than the network kept
+// it from sending at its target rate during the given second. It fell behind
+// by more than a tenth, or missed send slots for that long in rate mode,
+// while writes blocked for less than half of the shortfall.
+func (c *Client) clientBound(second SendIntervals, missed uint64) bool {
+	if second.Count == 0 {
+		return false
+	}
+	var shortfall time.Duration
+	if c.cfg.Rate > 0 {
+		shortfall = time.Duration(missed) * c.cfg.Interval
+	} else {
+		shortfall = second.Sum - time.Duration(second.Count)*c.cfg.Interval
+	}
+	return shortfall > second.Sum/10 && second.Blocked < shortfall/2
+}
@@ -0,0 +1,286 @@
+package client
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// PhaseResult summarizes the client's behaviour during one phase of the test,
+// see [Config.Phases]. Gaps and round-trip times are attributed to the phase
+// in which the message ending them was received.
+type PhaseResult struct {
+	Name string
+	// Duration is the time the client spent running in the phase.
+	Duration time.Duration
+
+	Sent, Received uint64
+	Bytes          uint64
+
+	// MaxGap is the longest time without receiving a message.
+	MaxGap time.Duration
+	// Stalls is the number of gaps longer than the stall threshold, and Stalled
+	// their total duration.
+	Stalls  int
+	Stalled time.Duration
+
+	// RTT holds the round-trip times of echoed messages, in echo mode only.
+	RTT RTTStats
+}
+
+// RTTStats summarizes round-trip times.
+type RTTStats struct {
+	Count         uint64
+	Min, Max, Sum time.Duration
+}
+
+func (s *RTTStats) add(rtt time.Duration) {
+	if s.Count == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	s.Max = max(s.Max, rtt)
+	s.Sum += rtt
+	s.Count++
+}
+
+// Avg returns the average round-trip time, or 0 without samples.
+func (s RTTStats) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Stall is a gap between two received messages longer than the stall
+// threshold.
+type Stall struct {
+	Start    time.Time
+	Duration time.Duration
+	// Phase is the phase the stall ended in, if tracked.
+	Phase string
+	// Direction is the direction the stall occurred in, one of
+	// [internal.DirectionClientToServer], [internal.DirectionServerToClient]
+	// or [DirectionBoth], with [Config.OneWayDelay].
+	Direction string
+	// ICMPSent and ICMPReceived are the ICMP echo requests sent during the
+	// stall and the replies received, with [Config.ICMPInterval]. Replies
+	// coming through while the flow stalls point at a disruption of the flow
+	// rather than of the path to the server.
+	ICMPSent, ICMPReceived uint64
+}
+
+// newStall returns a stall of the given start and duration, with the ICMP
+// echo requests sent and replies received during it.
+func (c *Client) newStall(start time.Time, d time.Duration, icmp icmpCounts) Stall {
+	return Stall{Start: start, Duration: d, Phase: c.phase(), ICMPSent: icmp.sent, ICMPReceived: icmp.received}
+}
+
+// maxStallList bounds the number of stalls listed in results.
+const maxStallList = 1000
+
+// stalls records the stalls of a client.
+type stalls struct {
+	mu    sync.Mutex
+	count int
+	total time.Duration
+	list  []Stall
+}
+
+func (s *stalls) add(stall Stall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.total += stall.Duration
+	if len(s.list) < maxStallList {
+		s.list = append(s.list, stall)
+	}
+}
+
+func (s *stalls) result() (int, time.Duration, []Stall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.total, slices.Clone(s.list)
+}
+
+// rttStats records the round-trip times of a client.
+type rttStats struct {
+	mu    sync.Mutex
+	stats RTTStats
+	hist  internal.Histogram
+	// interval and intervalHist hold the round-trip times since the last call
+	// to take.
+	interval     RTTStats
+	intervalHist internal.Histogram
+}
+
+func (s *rttStats) add(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.add(rtt)
+	s.hist.Add(rtt)
+	s.interval.add(rtt)
+	s.intervalHist.Add(rtt)
+}
+
+// take returns the summary and distribution of the round-trip times since the
+// last call.
+func (s *rttStats) take() (RTTStats, []internal.Bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval, buckets := s.interval, s.intervalHist.Buckets()
+	s.interval, s.intervalHist = RTTStats{}, internal.Histogram{}
+	return interval, buckets
+}
+
+// result returns the summary and 99th percentile of the round-trip times.
+func (s *rttStats) result() (RTTStats, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats, s.hist.Quantile(0.99)
+}
+
+// phaseStats holds the counters of every phase seen so far.
+type phaseStats struct {
+	mu sync.Mutex
+	// Phases in order of first appearance.
+	order  []string
+	byName map[string]*PhaseResult
+}
+
+// get returns the counters of the given phase. Must be called with mu held.
+func (p *phaseStats) get(name string) *PhaseResult {
+	if p.byName == nil {
+		p.byName = make(map[string]*PhaseResult)
+	}
+	r, ok := p.byName[name]
+	if !ok {
+		r = &PhaseResult{Name: name}
+		p.byName[name] = r
+		p.order = append(p.order, name)
+	}
+	return r
+}
+
+// phase returns the current phase, or "" if phases aren't tracked.
+func (c *Client) phase() string {
+	if c.cfg.Phases == nil {
+		return ""
+	}
+	return c.cfg.Phases.Current()
+}
+
+// send accounts for a message sent to the server.
+func (c *Client) send() {
+	c.stats.tx.Add(1)
+	c.stats.sent.Add(1)
+
+	if c.cfg.Phases == nil {
+		return
+	}
+	c.phases.mu.Lock()
+	defer c.phases.mu.Unlock()
+	c.phases.get(c.phase()).Sent++
+}
+
+// receive accounts for a valid message received from the server, with its
+// round-trip time and one-way delays if known. Must not be called
+// concurrently.
+func (c *Client) receive(rtt time.Duration, delays *oneWay) {
+	gap, icmp := c.stats.receive()
+	if gap > c.cfg.StallThreshold {
+		stall := c.newStall(time.Now().Add(-gap), gap, icmp)
+		if delays != nil {
+			stall.Direction = delays.direction(c.cfg.StallThreshold)
+		}
+		c.stalls.add(stall)
+		c.stats.stalled.Store(true)
+		c.reportStall(stall)
+	}
+	if rtt > 0 {
+		c.rtt.add(rtt)
+	}
+
+	if c.cfg.Phases == nil {
+		return
+	}
+	c.phases.mu.Lock()
+	defer c.phases.mu.Unlock()
+
+	r := c.phases.get(c.phase())
+	r.Received++
+	r.Bytes += internal.MsgSize
+	r.MaxGap = max(r.MaxGap, gap)
+	if gap > c.cfg.StallThreshold {
+		r.Stalls++
+		r.Stalled += gap
+	}
+	if rtt > 0 {
+		r.RTT.add(rtt)
+	}
+}
+
+// phaseResults returns the results of every phase the client ran in between
+// start and end.
+func (c *Client) phaseResults(start, end time.Time) []PhaseResult {
+	if c.cfg.Phases == nil {
+		return nil
+	}
+
+	c.phases.mu.Lock()
+	defer c.phases.mu.Unlock()
+
+	for _, span := range c.cfg.Phases.Spans(start, end) {
+		c.phases.get(span.Name).Duration += span.End.Sub(span.Start)
+	}
+
+	results := make([]PhaseResult, len(c.phases.order))
+	for i, name := range c.phases.order {
+		results[i] = *c.phases.byName[name]
+		// Durations are recomputed from the spans on every call.
+		c.phases.byName[name].Duration = 0
+	}
+	return results
+}
+
+// sendTimes remembers when recent messages were due and actually sent, to
+// determine their round-trip time and one-way delays once the reply arrives.
+type sendTimes struct {
+	mu   sync.Mutex
+	ring [4096]struct {
+		seq       uint64
+		due, sent time.Time
+	}
+}
+
+func (s *sendTimes) put(seq uint64, due, sent time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &s.ring[seq%uint64(len(s.ring))]
+	e.seq, e.due, e.sent = seq, due, sent
+}
+
+// rtt returns the round-trip time of the given message, counting from when
+// it was due, or 0 if it was forgotten already.
+func (s *sendTimes) rtt(seq uint64, received time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &s.ring[seq%uint64(len(s.ring))]
+	if e.seq != seq || e.due.IsZero() {
+		return 0
+	}
+	return received.Sub(e.due)
+}
+
+// sent returns when the given message was sent, or false if it was
+// forgotten already.
+func (s *sendTimes) sent(seq uint64) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &s.ring[seq%uint64(len(s.ring))]
+	if e.seq != seq || e.sent.IsZero() {
+		return time.Time{}, false
+	}
+	return e.sent, true
+}
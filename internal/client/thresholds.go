@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Thresholds are pass/fail criteria on top of the timeout, which ends a run
+// right away. Zero values disable a threshold. They are evaluated every second
+// while the client runs, stopping it on the first violation, and once more
+// when it stops.
+type Thresholds struct {
+	// MaxGap is the longest acceptable time without receiving a message.
+	MaxGap time.Duration `yaml:"max_gap"`
+	// MaxStalled is the longest acceptable total time spent in stalls, see
+	// [Config.StallThreshold].
+	MaxStalled time.Duration `yaml:"max_stalled"`
+	// MaxLoss is the largest acceptable fraction of requests left without a
+	// reply, in echo mode. Only evaluated when the client stops, as replies in
+	// flight aren't lost yet.
+	MaxLoss *float64 `yaml:"max_loss"`
+	// MaxP99RTT is the longest acceptable 99th percentile round-trip time, in
+	// echo mode. Evaluated while running once 100 replies were received.
+	MaxP99RTT time.Duration `yaml:"max_p99_rtt"`
+	// MinOpsPerSecond is the fewest messages that must be received in every
+	// one-second interval.
+	MinOpsPerSecond uint64 `yaml:"min_ops_per_second"`
+	// MaxDialRetries is the largest acceptable number of connection retries.
+	MaxDialRetries *int `yaml:"max_dial_retries"`
+}
+
+// ThresholdError reports a violated threshold.
+type ThresholdError struct {
+	// Threshold is the name of the threshold, as in a scenario file.
+	Threshold string
+	// Message describes the violation.
+	Message string
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("threshold %s violated: %s", e.Threshold, e.Message)
+}
+
+// Evaluation is the outcome of a single threshold.
+type Evaluation struct {
+	Threshold string
+	// Limit is the configured limit and Value the measured one, formatted.
+	Limit, Value string
+	// Err is the violation, nil if the threshold was met.
+	Err *ThresholdError
+}
+
+// Check returns a [*ThresholdError] naming the first threshold the result of
+// a stopped client violates.
+func (t Thresholds) Check(r Result) error {
+	return t.check(r, true)
+}
+
+// Evaluate evaluates every enabled threshold against the result of a stopped
+// client.
+func (t Thresholds) Evaluate(r Result) []Evaluation {
+	return t.evaluate(r, true)
+}
+
+// check returns the first violation of the thresholds by the result of a
+// client, see [Thresholds.evaluate].
+func (t Thresholds) check(r Result, final bool) error {
+	for _, e := range t.evaluate(r, final) {
+		if e.Err != nil {
+			return e.Err
+		}
+	}
+	return nil
+}
+
+// evaluate evaluates the thresholds against the result of a client.
+// Thresholds that can't be judged before the client stopped are skipped
+// unless final.
+func (t Thresholds) evaluate(r Result, final bool) []Evaluation {
+	var evals []Evaluation
+	add := func(threshold string, limit, value any, ok bool, format string, args ...any) {
+		e := Evaluation{Threshold: threshold, Limit: fmt.Sprint(limit), Value: fmt.Sprint(value)}
+		if !ok {
+			e.Err = &ThresholdError{Threshold: threshold, Message: fmt.Sprintf(format, args...)}
+		}
+		evals = append(evals, e)
+	}
+
+	if t.MaxGap > 0 {
+		add("max_gap", t.MaxGap, r.MaxGap, r.MaxGap <= t.MaxGap,
+			"longest gap %s exceeds %s", r.MaxGap, t.MaxGap)
+	}
+	if t.MaxStalled > 0 {
+		add("max_stalled", t.MaxStalled, r.Stalled, r.Stalled <= t.MaxStalled,
+			"%d stalls totalling %s exceed %s", r.Stalls, r.Stalled, t.MaxStalled)
+	}
+	if t.MaxLoss != nil && final {
+		var ratio float64
+		if r.Sent > 0 {
+			ratio = float64(r.Lost) / float64(r.Sent)
+		}
+		add("max_loss", *t.MaxLoss, fmt.Sprintf("%.4g", ratio), ratio <= *t.MaxLoss,
+			"%d of %d requests lost (%.4g) exceeds %.4g", r.Lost, r.Sent, ratio, *t.MaxLoss)
+	}
+	if t.MaxP99RTT > 0 && (final || r.RTT.Count >= 100) {
+		add("max_p99_rtt", t.MaxP99RTT, r.RTTP99, r.RTTP99 <= t.MaxP99RTT,
+			"99th percentile round-trip time %s exceeds %s", r.RTTP99, t.MaxP99RTT)
+	}
+	if t.MinOpsPerSecond > 0 && r.Intervals > 0 {
+		add("min_ops_per_second", t.MinOpsPerSecond, r.MinOpsPerSecond, r.MinOpsPerSecond >= t.MinOpsPerSecond,
+			"%d messages received in one second, fewer than %d", r.MinOpsPerSecond, t.MinOpsPerSecond)
+	}
+	if t.MaxDialRetries != nil {
+		add("max_dial_retries", *t.MaxDialRetries, r.DialRetries, r.DialRetries <= *t.MaxDialRetries,
+			"%d connection retries exceed %d", r.DialRetries, *t.MaxDialRetries)
+	}
+	return evals
+}
+
+// rates tracks the messages received in every one-second interval, and the
+// intervals in which the client was the bottleneck.
+type rates struct {
+	mu          sync.Mutex
+	intervals   int
+	min         uint64
+	clientBound int
+}
+
+// monitor measures the receive rate and evaluates the thresholds every second
+// until ctx is cancelled. On a violation, it stops the client through cancel
+// and returns the error.
+func (c *Client) monitor(ctx context.Context, cancel context.CancelFunc) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	c.stats.second.Store(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		received := c.stats.second.Swap(0)
+		sent, missed := c.pacing.takeSecond(), c.stats.missedSecond.Swap(0)
+		bound := c.clientBound(sent, missed)
+		c.rates.mu.Lock()
+		if c.rates.intervals == 0 || received < c.rates.min {
+			c.rates.min = received
+		}
+		c.rates.intervals++
+		if bound {
+			c.rates.clientBound++
+		}
+		c.rates.mu.Unlock()
+
+		if bound {
+			attrs := []any{"send_rate", math.Round(sent.Rate()*10) / 10, "target_rate", c.targetRate(), "jitter", sent.Jitter(), "blocked", sent.Blocked}
+			if c.cfg.Rate > 0 {
+				attrs = append(attrs, "missed_slots", missed)
+			}
+			c.log.Warn("Client is the bottleneck, sending below the target rate without the network holding it back", attrs...)
+		}
+
+		if err := c.cfg.Thresholds.check(c.Result(), false); err != nil {
+			c.log.Error("Stopping on violated threshold", "error", err)
+			cancel()
+			return err
+		}
+	}
+}
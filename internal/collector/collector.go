@@ -0,0 +1,206 @@
+// Package collector implements an HTTP service that many clients push their
+// events and results to, so that the outcome of a test spanning dozens of
+// client pods can be judged in one place. It exposes the following API:
+//
+//	POST /v1/events    Record an [Event].
+//	POST /v1/results   Record the [Results] of a client, replacing earlier ones.
+//	GET  /v1/events    List all events recorded so far.
+//	GET  /v1/report    Aggregate all results into a [report.Report]. Returns
+//	                   JSON, or text with format=text.
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+// Event kinds sent by clients.
+const (
+	// EventReady is sent once traffic flows to a target.
+	EventReady = "ready"
+	// EventFailed is sent when a target fails.
+	EventFailed = "failed"
+	// EventDone is sent when a target stops without failure.
+	EventDone = "done"
+	// EventPhase is sent when the client enters a new phase, named by the
+	// event's phase.
+	EventPhase = "phase"
+	// EventStall is sent when a stall of a target ends, or when the target
+	// fails during one, with its duration.
+	EventStall = "stall"
+)
+
+// Event is something that happened on a client.
+type Event struct {
+	Client string `json:"client"`
+	// Target is the label of the target the event relates to, if any.
+	Target string `json:"target,omitempty"`
+	// Phase is the phase of the test the event happened in, if tracked.
+	Phase   string    `json:"phase,omitempty"`
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message,omitempty"`
+	// DurationSeconds is the duration of a stall.
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// Results are the flows of a client.
+type Results struct {
+	Client string       `json:"client"`
+	Flows  report.Flows `json:"flows"`
+}
+
+// maxEvents bounds the memory used for events. Later events are dropped.
+const maxEvents = 100_000
+
+// maxBodySize bounds the size of pushed events and results.
+const maxBodySize = 1 << 20
+
+// Collector aggregates the events and results of many clients.
+type Collector struct {
+	expected int
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	events  []Event
+	dropped int
+	results map[string]report.Flows
+}
+
+// New returns a collector expecting results from the given number of clients,
+// 0 if unknown. Progress messages go to log, which defaults to
+// [internal.DefaultLogger].
+func New(expected int, log *slog.Logger) *Collector {
+	if log == nil {
+		log = internal.DefaultLogger()
+	}
+	return &Collector{expected: expected, log: log, results: make(map[string]report.Flows)}
+}
+
+// Report aggregates the results received so far.
+func (c *Collector) Report() report.Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return report.Aggregate(c.results, c.expected)
+}
+
+// Events returns the events received so far.
+func (c *Collector) Events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+// Handler returns the handler of the collector's HTTP API.
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		if err := decode(w, r, &ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ev.Time.IsZero() {
+			ev.Time = time.Now()
+		}
+		c.addEvent(ev)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /v1/results", func(w http.ResponseWriter, r *http.Request) {
+		var res Results
+		if err := decode(w, r, &res); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.addResults(res)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.Events())
+	})
+
+	mux.HandleFunc("GET /v1/report", func(w http.ResponseWriter, r *http.Request) {
+		rep := c.Report()
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintln(w, rep)
+			return
+		}
+		writeJSON(w, rep)
+	})
+
+	return mux
+}
+
+func (c *Collector) addEvent(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.events) >= maxEvents {
+		if c.dropped == 0 {
+			c.log.Warn("Event limit reached, dropping further events", "limit", maxEvents)
+		}
+		c.dropped++
+		return
+	}
+	c.events = append(c.events, ev)
+
+	attrs := []any{"client", ev.Client, "kind", ev.Kind}
+	if ev.Target != "" {
+		attrs = append(attrs, internal.LogTarget, ev.Target)
+	}
+	if ev.Phase != "" {
+		attrs = append(attrs, "phase", ev.Phase)
+	}
+	if ev.Message != "" {
+		attrs = append(attrs, "message", ev.Message)
+	}
+	if ev.DurationSeconds != 0 {
+		attrs = append(attrs, "duration_seconds", ev.DurationSeconds)
+	}
+	c.log.Info("Event", attrs...)
+}
+
+func (c *Collector) addResults(res Results) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results[res.Client] = res.Flows
+	c.log.Info("Results received", "client", res.Client, "clients", len(c.results))
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("decode body: %w", err)
+	}
+
+	var client string
+	switch v := v.(type) {
+	case *Event:
+		client = v.Client
+	case *Results:
+		client = v.Client
+	}
+	if client == "" {
+		return errors.New("missing client")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+func TestCollector(t *testing.T) {
+	c := New(3, internal.DiscardLogger())
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	push := func(client string, flows ...report.Flow) {
+		t.Helper()
+		p := NewPusher(srv.URL, client, internal.DiscardLogger())
+		for _, f := range flows {
+			p.Event(Event{Target: f.Name, Kind: EventReady})
+		}
+		if err := p.Results(context.Background(), flows); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	push("c1", report.Flow{Name: "pod", OK: true, MaxGapSeconds: 0.1})
+	push("c2",
+		report.Flow{Name: "pod", OK: true, MaxGapSeconds: 0.2},
+		report.Flow{Name: "svc", Error: "timeout", MaxGapSeconds: 1.2},
+	)
+
+	resp, err := http.Get(srv.URL + "/v1/report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var rep report.Report
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Verdict != report.VerdictFail || rep.Clients != 2 || rep.Flows != 3 || rep.FailedFlows != 1 || rep.MaxGapFlow != "c2/svc" {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if got := len(c.Events()); got != 3 {
+		t.Errorf("expected 3 events, got %d", got)
+	}
+
+	// Results replace earlier ones of the same client.
+	push("c2", report.Flow{Name: "pod", OK: true}, report.Flow{Name: "svc", OK: true})
+	if rep := c.Report(); rep.Verdict != report.VerdictIncomplete || rep.FailedFlows != 0 {
+		t.Errorf("expected incomplete verdict without failures, got %+v", rep)
+	}
+
+	push("c3")
+	if rep := c.Report(); rep.Verdict != report.VerdictPass {
+		t.Errorf("expected pass verdict, got %+v", rep)
+	}
+}
+
+func TestCollectorInvalid(t *testing.T) {
+	srv := httptest.NewServer(New(0, internal.DiscardLogger()).Handler())
+	defer srv.Close()
+
+	p := NewPusher(srv.URL, "", internal.DiscardLogger())
+	if err := p.Results(context.Background(), nil); err == nil {
+		t.Fatal("expected results without client to be rejected")
+	}
+}
+
+func TestPusherDrops(t *testing.T) {
+	// Hold back every event until the queue overflowed.
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	p := NewPusher(srv.URL, "a", slog.New(slog.NewTextHandler(&out, nil)))
+	for range 2000 {
+		p.Event(Event{Kind: EventStall, DurationSeconds: 1})
+	}
+	close(unblock)
+	p.Close()
+
+	if p.Dropped() == 0 {
+		t.Fatal("expected events to be dropped")
+	}
+	if n := strings.Count(out.String(), "Dropping events"); n != 1 {
+		t.Errorf("expected a single warning, got %d:\n%s", n, out.String())
+	}
+}
+
+func TestPusherUnresponsive(t *testing.T) {
+	// Blackhole every request, as a collector that stopped responding would.
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stop
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	var out bytes.Buffer
+	p := NewPusher(srv.URL, "a", slog.New(slog.NewTextHandler(&out, nil)))
+	for range 10 {
+		p.Event(Event{Kind: EventStall, DurationSeconds: 1})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.Results(ctx, nil); err == nil {
+		t.Error("expected an error sending results")
+	}
+	if d := time.Since(start); d > pushTimeout {
+		t.Errorf("Results took %s", d)
+	}
+	p.Close()
+
+	if d := p.Dropped(); d != 10 {
+		t.Errorf("expected 10 events dropped, got %d", d)
+	}
+	if n := strings.Count(out.String(), "Error pushing events"); n != 1 {
+		t.Errorf("expected a single warning, got %d:\n%s", n, out.String())
+	}
+}
+
+func TestPusherFailing(t *testing.T) {
+	// Fail the first events, then accept the rest.
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n++; n <= 5 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	p := NewPusher(srv.URL, "a", slog.New(slog.NewTextHandler(&out, nil)))
+	for range 10 {
+		p.Event(Event{Kind: EventStall, DurationSeconds: 1})
+	}
+	if err := p.Results(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := p.Dropped(); d != 5 {
+		t.Errorf("expected 5 events dropped, got %d", d)
+	}
+	if n := strings.Count(out.String(), "Error pushing events"); n != 1 {
+		t.Errorf("expected a single warning, got %d:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "Resumed pushing events to collector\" failed=5") {
+		t.Errorf("expected pushing to resume:\n%s", out.String())
+	}
+}
@@ -0,0 +1,179 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal/report"
+)
+
+// pushTimeout bounds every request to the collector.
+const pushTimeout = 5 * time.Second
+
+// Pusher sends a client's events and results to a collector.
+type Pusher struct {
+	url    string
+	client string
+	log    *slog.Logger
+	http   *http.Client
+	// cancel abandons pushing the queued events.
+	cancel context.CancelFunc
+
+	// mu guards closing events against concurrent sends, and the counters of
+	// dropped events.
+	mu     sync.Mutex
+	closed bool
+	events chan Event
+	done   chan struct{}
+	// dropped is the number of events not pushed in total, and dropping the
+	// number dropped since the queue last accepted one.
+	dropped, dropping uint64
+}
+
+// NewPusher returns a pusher to the collector at url for the given client.
+// Errors pushing events are logged to log.
+func NewPusher(url, client string, log *slog.Logger) *Pusher {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pusher{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+		log:    log,
+		http:   &http.Client{Timeout: pushTimeout},
+		cancel: cancel,
+		events: make(chan Event, 1024),
+		done:   make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// Event queues an event for sending without blocking. The client and time
+// are filled in. Events are dropped if the collector can't keep up, which is
+// preferable to stalling the client. A warning is logged when events start
+// being dropped and when the queue accepts them again.
+func (p *Pusher) Event(ev Event) {
+	ev.Client, ev.Time = p.client, time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.events <- ev:
+		if p.dropping > 0 {
+			p.log.Warn("Resumed pushing events to collector", "dropped", p.dropping)
+			p.dropping = 0
+		}
+	default:
+		if p.dropping == 0 {
+			p.log.Warn("Dropping events for collector, queue is full")
+		}
+		p.dropped++
+		p.dropping++
+	}
+}
+
+// Dropped returns the number of events not pushed: dropped because the queue
+// was full, failed to push, or abandoned when closing.
+func (p *Pusher) Dropped() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+func (p *Pusher) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropped++
+}
+
+// run pushes queued events until the queue is closed, or drops them once ctx
+// is cancelled. A warning is logged when pushing starts failing and when it
+// succeeds again, rather than for every event while the collector is down.
+func (p *Pusher) run(ctx context.Context) {
+	defer close(p.done)
+	var failed uint64
+	for ev := range p.events {
+		if ctx.Err() != nil {
+			p.drop()
+			continue
+		}
+		err := p.post(ctx, "/v1/events", ev)
+		if err != nil {
+			if failed == 0 {
+				p.log.Warn("Error pushing events to collector", "error", err)
+			}
+			failed++
+			p.drop()
+			continue
+		}
+		if failed > 0 {
+			p.log.Warn("Resumed pushing events to collector", "failed", failed)
+			failed = 0
+		}
+	}
+}
+
+// Results sends the client's results, after any queued events. The events
+// still queued when ctx expires are abandoned.
+func (p *Pusher) Results(ctx context.Context, flows report.Flows) error {
+	p.closeEvents()
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+	}
+	return p.post(ctx, "/v1/results", Results{Client: p.client, Flows: flows})
+}
+
+// Close stops accepting events and abandons those still queued, see Results
+// to send them first. It is safe to call more than once.
+func (p *Pusher) Close() {
+	p.closeEvents()
+	p.cancel()
+	<-p.done
+}
+
+func (p *Pusher) closeEvents() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+}
+
+func (p *Pusher) post(ctx context.Context, path string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
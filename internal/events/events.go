@@ -0,0 +1,142 @@
+// Package events defines the structured event log of clients and servers,
+// which records a run in enough detail to analyze it offline. The log is
+// written as JSON lines by a [log/slog] JSON handler, one event per line:
+//
+//	{"time":"...","level":"INFO","msg":"interval","source":"client","target":"a","tx":100,...}
+//
+// The msg field holds the kind of the event, the other fields depend on it.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Sources of events.
+const (
+	SourceClient = "client"
+	SourceServer = "server"
+)
+
+// Kinds of events, logged as the message.
+const (
+	// Connected is logged by a client target once connected.
+	Connected = "connected"
+	// Interval is logged by a client target every second with its counters.
+	Interval = "interval"
+	// Stall is logged by a client target when a gap longer than the stall
+	// threshold ends, or when the client stops during one.
+	Stall = "stall"
+	// Phase is logged by a client when entering a phase.
+	Phase = "phase"
+	// Done is logged by a client target when it stops, with its result.
+	Done = "done"
+	// Accepted is logged by a server for every new connection.
+	Accepted = "accepted"
+	// Closed is logged by a server when a connection ends.
+	Closed = "closed"
+)
+
+// Reasons for a server closing a connection.
+const (
+	// ClosePeer is the client closing the connection.
+	ClosePeer = "peer"
+	// CloseError is an error on the connection.
+	CloseError = "error"
+	// CloseShutdown is the server shutting down.
+	CloseShutdown = "shutdown"
+)
+
+// Event is a single event of the log. Fields not applicable to its kind are
+// zero.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"msg"`
+	Source string    `json:"source"`
+	// Target is the label of the client target the event relates to.
+	Target string `json:"target"`
+	// Local and Remote are the addresses of the connection.
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	// Phase is the phase of the test, if tracked.
+	Phase string `json:"phase"`
+
+	// Counters of an interval.
+	TX       uint64 `json:"tx"`
+	RX       uint64 `json:"rx"`
+	Bytes    uint64 `json:"bytes"`
+	InFlight uint64 `json:"in_flight"`
+	Stalled  bool   `json:"stalled"`
+	// RTTBuckets is the distribution of round-trip times of an interval, as
+	// pairs of the bucket's upper bound in nanoseconds and count.
+	RTTBuckets [][2]uint64 `json:"rtt_buckets"`
+
+	// Start and duration of a stall, and the ICMP echo requests sent and
+	// replies received during it if the client probed with ICMP.
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ICMPSent        uint64    `json:"icmp_sent"`
+	ICMPReceived    uint64    `json:"icmp_received"`
+
+	// Result of a target.
+	Sent          uint64  `json:"sent"`
+	Received      uint64  `json:"received"`
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+	// Error is the error a target failed with, or a connection was closed
+	// with, and Failure its kind.
+	Error   string `json:"error"`
+	Failure string `json:"failure"`
+
+	// Reason is why a server closed a connection, one of the Close constants.
+	Reason string `json:"reason"`
+}
+
+// Open creates the event log at path and returns a logger writing to it on
+// behalf of the given source.
+func Open(path, source string) (*slog.Logger, io.Closer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create event log: %w", err)
+	}
+	return New(file, source), file, nil
+}
+
+// New returns a logger writing events to w on behalf of the given source.
+func New(w io.Writer, source string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, nil)).With("source", source)
+}
+
+// Read reads all events from r. Lines that aren't events, such as other
+// output mixed into the log, are skipped.
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.Kind == "" || ev.Time.IsZero() {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// ReadFile reads all events from the file at path, see [Read].
+func ReadFile(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	events, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return events, nil
+}
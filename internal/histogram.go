@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"math/bits"
+	"time"
+)
+
+// histogramSubBuckets is the number of buckets every power of two is split
+// into, bounding the relative error of quantiles to 1/16.
+const histogramSubBuckets = 16
+
+// Histogram records a distribution of durations in logarithmic buckets of
+// bounded relative error, in constant memory. The zero value is ready to use.
+// It is not safe for concurrent use.
+type Histogram struct {
+	counts   [64 * histogramSubBuckets]uint64
+	count    uint64
+	min, max time.Duration
+}
+
+// histogramBucket returns the index of the bucket holding d.
+func histogramBucket(d time.Duration) int {
+	v := uint64(max(d, 0))
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// Keep the top bits of the value, including the leading one.
+	shift := bits.Len64(v) - bits.Len64(histogramSubBuckets)
+	return (shift+1)*histogramSubBuckets + int(v>>shift) - histogramSubBuckets
+}
+
+// histogramUpperBound returns the largest duration in bucket i.
+func histogramUpperBound(i int) time.Duration {
+	if i < histogramSubBuckets {
+		return time.Duration(i)
+	}
+	shift := i/histogramSubBuckets - 1
+	top := uint64(i%histogramSubBuckets + histogramSubBuckets)
+	return time.Duration((top+1)<<shift - 1)
+}
+
+// Add records a duration.
+func (h *Histogram) Add(d time.Duration) {
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.counts[histogramBucket(d)]++
+	h.count++
+}
+
+// Count returns the number of durations recorded.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Quantile returns the duration below which the given fraction of the
+// recorded durations fall, or 0 if none were recorded. The result errs on the
+// high side by at most 1/16, but never exceeds the largest duration recorded.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	rank = min(max(rank, 1), h.count)
+
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(max(histogramUpperBound(i), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Bucket is a bucket of a [Histogram].
+type Bucket struct {
+	// Upper is the largest duration in the bucket.
+	Upper time.Duration
+	Count uint64
+}
+
+// Buckets returns the non-empty buckets of the histogram, in order.
+func (h *Histogram) Buckets() []Bucket {
+	var buckets []Bucket
+	for i, n := range h.counts {
+		if n > 0 {
+			buckets = append(buckets, Bucket{Upper: histogramUpperBound(i), Count: n})
+		}
+	}
+	return buckets
+}
+
+// AddBuckets records the durations of the given buckets, as returned by
+// [Histogram.Buckets], for example to merge histograms. The durations are
+// taken to be the upper bound of their bucket.
+func (h *Histogram) AddBuckets(buckets []Bucket) {
+	for _, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		if h.count == 0 || b.Upper < h.min {
+			h.min = b.Upper
+		}
+		h.max = max(h.max, b.Upper)
+		h.counts[histogramBucket(b.Upper)] += b.Count
+		h.count += b.Count
+	}
+}
+
+// Max returns the largest duration recorded.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
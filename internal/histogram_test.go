@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, 15, 16, 17, 31, 32, 1000, time.Millisecond, time.Hour} {
+		i := histogramBucket(d)
+		if upper := histogramUpperBound(i); d > upper {
+			t.Errorf("%d in bucket %d with upper bound %d", d, i, upper)
+		}
+		if i > 0 {
+			if lower := histogramUpperBound(i - 1); d <= lower {
+				t.Errorf("%d in bucket %d, but previous bucket holds up to %d", d, i, lower)
+			}
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	if q := h.Quantile(0.99); q != 0 {
+		t.Fatalf("empty histogram: got %s", q)
+	}
+
+	for i := 1; i <= 1000; i++ {
+		h.Add(time.Duration(i) * time.Microsecond)
+	}
+
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Microsecond},
+		{0.5, 500 * time.Microsecond},
+		{0.99, 990 * time.Microsecond},
+		{1, 1000 * time.Microsecond},
+	} {
+		got := h.Quantile(tt.q)
+		if got < tt.want || got > tt.want+tt.want/16 {
+			t.Errorf("quantile %v: got %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b Histogram
+	for i := 1; i <= 100; i++ {
+		a.Add(time.Duration(i) * time.Millisecond)
+		b.Add(time.Duration(i+100) * time.Millisecond)
+	}
+
+	var merged Histogram
+	merged.AddBuckets(a.Buckets())
+	merged.AddBuckets(b.Buckets())
+
+	if merged.Count() != 200 {
+		t.Fatalf("expected 200 durations, got %d", merged.Count())
+	}
+	if got, want := merged.Quantile(0.5), 100*time.Millisecond; got < want || got > want+want/16 {
+		t.Errorf("median: got %s, want %s", got, want)
+	}
+	if got, want := merged.Max(), 200*time.Millisecond; got < want || got > want+want/16 {
+		t.Errorf("max: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogFormats are the supported log formats.
+var LogFormats = []string{LogFormatText, LogFormatJSON}
+
+// Attribute keys shared by the log messages of all components, and with the
+// event log, so messages about the same connection can be correlated.
+const (
+	// LogLocal and LogRemote are the addresses of a connection.
+	LogLocal  = "local"
+	LogRemote = "remote"
+	// LogTarget is the label of a client target.
+	LogTarget = "target"
+	// LogSeq is a message sequence number.
+	LogSeq = "seq"
+)
+
+// NewLogger returns a logger writing messages of at least the given level to w
+// in one of [LogFormats]. Timestamps have nanosecond precision, to line up the
+// logs of client, server and other agents.
+func NewLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(a.Key, a.Value.Time().Format(time.RFC3339Nano))
+			}
+			return a
+		},
+	}
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q", format)
+}
+
+// ParseLogLevel parses a log level such as "info" or "warn".
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// DiscardLogger returns a logger dropping all messages.
+func DiscardLogger() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// ConnAttrs returns the attributes of the local and remote address of conn.
+func ConnAttrs(conn net.Conn) slog.Attr {
+	return slog.Group("", LogLocal, addrString(conn.LocalAddr()), LogRemote, addrString(conn.RemoteAddr()))
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// DefaultLogger returns the logger of components configured without one,
+// writing text to stdout.
+func DefaultLogger() *slog.Logger {
+	log, _ := NewLogger(os.Stdout, LogFormatText, slog.LevelInfo)
+	return log
+}
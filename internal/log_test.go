@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	level, err := ParseLogLevel("warn")
+	if err != nil || level != slog.LevelWarn {
+		t.Fatalf("expected warn level, got %s, %v", level, err)
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Fatal("expected error for invalid level")
+	}
+
+	var buf bytes.Buffer
+	log, err := NewLogger(&buf, LogFormatJSON, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	log.Info("Operations per second", "tx", 1)
+	log.Warn("Stream resumed after a gap", ConnAttrs(client), LogSeq, 42)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning, got %q", lines)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, msg["time"].(string)); err != nil {
+		t.Errorf("invalid timestamp: %s", err)
+	}
+	if msg["level"] != "WARN" || msg[LogLocal] != "pipe" || msg[LogRemote] != "pipe" || msg[LogSeq] != 42.0 {
+		t.Errorf("unexpected message %v", msg)
+	}
+
+	if _, err := NewLogger(&buf, "xml", level); err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+}
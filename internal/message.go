@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Messages are MsgSize bytes long. The first seqSize bytes hold a big-endian
+// sequence number, the remainder is a random payload that stays the same for
+// the lifetime of a connection.
+const seqSize = 8
+
+// Payload is the fixed part of all messages sent over a connection.
+type Payload [MsgSize - seqSize]byte
+
+// NewPayload returns a random payload.
+func NewPayload() (Payload, error) {
+	var p Payload
+	if _, err := rand.Read(p[:]); err != nil {
+		return p, fmt.Errorf("generate random payload: %w", err)
+	}
+	return p, nil
+}
+
+// Encode writes a message with the given sequence number into buf, which must
+// be at least MsgSize bytes long.
+func (p *Payload) Encode(buf []byte, seq uint64) {
+	binary.BigEndian.PutUint64(buf, seq)
+	copy(buf[seqSize:], p[:])
+}
+
+// ErrInvalidMessage matches the errors returned by [Payload.Verify].
+var ErrInvalidMessage = errors.New("invalid message")
+
+// invalidMessageError is a verification failure, matching [ErrInvalidMessage].
+type invalidMessageError string
+
+func (e invalidMessageError) Error() string { return string(e) }
+
+func (e invalidMessageError) Is(target error) bool { return target == ErrInvalidMessage }
+
+// Verify returns an error matching [ErrInvalidMessage] if msg doesn't carry
+// the payload and the given sequence number.
+func (p *Payload) Verify(msg []byte, seq uint64) error {
+	if got := Seq(msg); got != seq {
+		return invalidMessageError(fmt.Sprintf("unexpected sequence number %d, expected %d", got, seq))
+	}
+	if !bytes.Equal(msg[seqSize:MsgSize], p[:]) {
+		return invalidMessageError(fmt.Sprintf("invalid payload %v, expected %v", msg[seqSize:MsgSize], p[:]))
+	}
+	return nil
+}
+
+// Seq returns the sequence number of msg.
+func Seq(msg []byte) uint64 {
+	return binary.BigEndian.Uint64(msg)
+}
+
+// StampSize is the size of the timestamps a server appends to stamped replies
+// in echo mode: when it received the message and when it sent it back, as
+// big-endian Unix timestamps in nanoseconds.
+const StampSize = 16
+
+// Stamp writes the timestamps of a stamped reply into buf, after the message.
+// buf must be at least MsgSize+StampSize bytes long.
+func Stamp(buf []byte, received, sent time.Time) {
+	binary.BigEndian.PutUint64(buf[MsgSize:], uint64(received.UnixNano()))
+	binary.BigEndian.PutUint64(buf[MsgSize+8:], uint64(sent.UnixNano()))
+}
+
+// Stamps returns the timestamps of a stamped reply, see [Stamp].
+func Stamps(buf []byte) (received, sent time.Time) {
+	received = time.Unix(0, int64(binary.BigEndian.Uint64(buf[MsgSize:])))
+	sent = time.Unix(0, int64(binary.BigEndian.Uint64(buf[MsgSize+8:])))
+	return received, sent
+}
+
+// Traffic modes, determining which side of a connection drives the flow.
+const (
+	// ModeEcho has the client send messages, which the server echoes back.
+	ModeEcho = "echo"
+	// ModeReverse has the server send messages, which the client echoes back.
+	ModeReverse = "reverse"
+	// ModeDuplex has client and server each send their own independent stream
+	// of messages, which the other side validates.
+	ModeDuplex = "duplex"
+)
+
+// Modes lists all supported traffic modes.
+var Modes = []string{ModeEcho, ModeReverse, ModeDuplex}
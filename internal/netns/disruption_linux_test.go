@@ -0,0 +1,247 @@
+//go:build amd64 || arm64
+
+package netns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+	"github.com/cilium/test-connection-disruption/internal/server"
+	"github.com/cilium/test-connection-disruption/internal/testutil"
+)
+
+const (
+	// Client timeout. Disruptions shorter than this must go unnoticed, longer
+	// ones must fail the client.
+	timeout = time.Second
+	// Time into the run at which the disruption starts.
+	disruptAfter = 500 * time.Millisecond
+	// Total run time of the client.
+	runTime = 3 * time.Second
+	// Duration of short and long disruptions. Short ones still end before
+	// TCP's second retransmission, so the flow resumes within the timeout.
+	short = 300 * time.Millisecond
+	long  = 2 * time.Second
+	// Gaps counting as a stall. Well above the hiccups of a busy machine, so
+	// undisrupted runs don't stall, but below short disruptions.
+	stallThreshold = 200 * time.Millisecond
+)
+
+func setup(t *testing.T) *Pair {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("requires the ip tool")
+	}
+
+	p, err := NewPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := p.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return p
+}
+
+func requireTool(t *testing.T, name string) {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("requires the %s tool", name)
+	}
+}
+
+// startServer runs an echo server in the server namespace until the test ends
+// and returns its address.
+func startServer(t *testing.T, p *Pair) string {
+	t.Helper()
+
+	var srv *server.Server
+	err := p.Server.Do(func() (err error) {
+		srv, err = server.Listen(server.Config{
+			Transport: internal.TransportTCP,
+			Mode:      internal.ModeEcho,
+			Interval:  10 * time.Millisecond,
+			Timeout:   timeout,
+			Log:       testutil.Logger(t),
+		}, net.JoinHostPort(p.ServerIP, "8000"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return srv.Addr().String()
+}
+
+// runClient runs a client in the client namespace for runTime and calls
+// disrupt once traffic has been flowing for disruptAfter. Returns the client's
+// result and error once both the client and the disruption are done.
+func runClient(t *testing.T, p *Pair, addr string, disrupt func(t *testing.T, p *Pair)) (client.Result, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), runTime)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	c := client.New(client.Config{
+		Addr:      addr,
+		Transport: internal.TransportTCP,
+		Mode:      internal.ModeEcho,
+		Interval:  10 * time.Millisecond,
+		Timeout:   timeout,
+		// Keep the default of five intervals from counting scheduling delays.
+		StallThreshold: stallThreshold,
+		Log:            testutil.Logger(t),
+		OnReady: func() {
+			wg.Add(1)
+			time.AfterFunc(disruptAfter, func() {
+				defer wg.Done()
+				disrupt(t, p)
+			})
+		},
+	})
+
+	err := p.Client.Do(func() error { return c.Run(ctx) })
+	wg.Wait()
+	return c.Result(), err
+}
+
+// restoreAfter runs f after d, reporting errors to t.
+func restoreAfter(t *testing.T, d time.Duration, f func() error) {
+	time.Sleep(d)
+	if err := f(); err != nil {
+		t.Error("restore:", err)
+	}
+}
+
+func linkDown(d time.Duration) func(*testing.T, *Pair) {
+	return func(t *testing.T, p *Pair) {
+		if err := p.Client.IP("link", "set", p.ClientDev, "down"); err != nil {
+			t.Error(err)
+			return
+		}
+		restoreAfter(t, d, func() error { return p.Client.IP("link", "set", p.ClientDev, "up") })
+	}
+}
+
+func routeFlap(d time.Duration) func(*testing.T, *Pair) {
+	return func(t *testing.T, p *Pair) {
+		route := []string{p.Subnet, "dev", p.ClientDev, "src", p.ClientIP}
+		if err := p.Client.IP(append([]string{"route", "del"}, route...)...); err != nil {
+			t.Error(err)
+			return
+		}
+		restoreAfter(t, d, func() error { return p.Client.IP(append([]string{"route", "add"}, route...)...) })
+	}
+}
+
+func mtu(mtu string) func(*testing.T, *Pair) {
+	return func(t *testing.T, p *Pair) {
+		if err := p.Client.IP("link", "set", p.ClientDev, "mtu", mtu); err != nil {
+			t.Error(err)
+		}
+		if err := p.Server.IP("link", "set", p.ServerDev, "mtu", mtu); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// nftables installs a rule with the given verdict for traffic to the server
+// in the client namespace and removes it again after d.
+func nftables(verdict string, d time.Duration) func(*testing.T, *Pair) {
+	return func(t *testing.T, p *Pair) {
+		cmds := [][]string{
+			{"add", "table", "inet", "tcd"},
+			{"add", "chain", "inet", "tcd", "output", "{ type filter hook output priority 0 ; }"},
+			{"add", "rule", "inet", "tcd", "output", "ip", "daddr", p.ServerIP, "tcp", "dport", "8000", verdict},
+		}
+		for _, cmd := range cmds {
+			if err := p.Client.Exec("nft", cmd...); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		restoreAfter(t, d, func() error { return p.Client.Exec("nft", "delete", "table", "inet", "tcd") })
+	}
+}
+
+func TestDisruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    string
+		disrupt func(*testing.T, *Pair)
+		// Expected client error, nil if the client must ride out the
+		// disruption.
+		err error
+		// Whether a client riding out the disruption must report a stall.
+		stall bool
+	}{
+		{name: "none", disrupt: func(*testing.T, *Pair) {}},
+		{name: "link-down-short", disrupt: linkDown(short), stall: true},
+		{name: "link-down-long", disrupt: linkDown(long), err: os.ErrDeadlineExceeded},
+		{name: "route-flap-short", disrupt: routeFlap(short), stall: true},
+		{name: "route-flap-long", disrupt: routeFlap(long), err: os.ErrDeadlineExceeded},
+		// Messages are far smaller than the lowest IPv6 MTU, so lowering it
+		// must not hold back the flow.
+		{name: "mtu", disrupt: mtu("1280")},
+		{name: "nft-drop-short", tool: "nft", disrupt: nftables("drop", short), stall: true},
+		{name: "nft-drop-long", tool: "nft", disrupt: nftables("drop", long), err: os.ErrDeadlineExceeded},
+		{name: "nft-reject", tool: "nft", disrupt: nftables("reject with tcp reset", short), err: syscall.ECONNRESET},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.tool != "" {
+				requireTool(t, tt.tool)
+			}
+
+			p := setup(t)
+			addr := startServer(t, p)
+
+			res, err := runClient(t, p, addr, tt.disrupt)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected client to fail with %v, got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("expected client to ride out the disruption, got:", err)
+			}
+			if stalled := res.Stalls > 0; stalled != tt.stall {
+				t.Fatalf("expected stall %t, got %d stalls with the longest gap %s", tt.stall, res.Stalls, res.MaxGap)
+			}
+		})
+	}
+}
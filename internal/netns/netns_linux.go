@@ -0,0 +1,156 @@
+//go:build amd64 || arm64
+
+// Package netns provides a test harness that connects two network namespaces
+// with a veth pair, to reproduce packet loss and other datapath disruptions
+// between client and server on a single machine. It requires root and the ip
+// tool from iproute2.
+package netns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// Namespace is a named network namespace as managed by ip-netns(8).
+type Namespace struct {
+	Name string
+}
+
+// New creates a network namespace with the given name and brings up its
+// loopback interface.
+func New(name string) (*Namespace, error) {
+	if err := ip("netns", "add", name); err != nil {
+		return nil, err
+	}
+	ns := &Namespace{Name: name}
+	if err := ns.IP("link", "set", "lo", "up"); err != nil {
+		ns.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Close deletes the namespace.
+func (ns *Namespace) Close() error {
+	return ip("netns", "delete", ns.Name)
+}
+
+// IP runs the ip tool inside the namespace.
+func (ns *Namespace) IP(args ...string) error {
+	return ip(append([]string{"-n", ns.Name}, args...)...)
+}
+
+// Exec runs a command inside the namespace.
+func (ns *Namespace) Exec(name string, args ...string) error {
+	return run("ip", append([]string{"netns", "exec", ns.Name, name}, args...)...)
+}
+
+// Do runs f on an OS thread that has joined the namespace. Sockets created by
+// f belong to the namespace for their lifetime, even when used from other
+// goroutines later on.
+func (ns *Namespace) Do(f func() error) error {
+	target, err := os.Open("/run/netns/" + ns.Name)
+	if err != nil {
+		return fmt.Errorf("open namespace: %w", err)
+	}
+	defer target.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		// Never unlock the thread. The runtime terminates it when the goroutine
+		// exits, so no other goroutine ends up running in the namespace.
+		runtime.LockOSThread()
+
+		if err := setns(target); err != nil {
+			errc <- err
+			return
+		}
+		errc <- f()
+	}()
+	return <-errc
+}
+
+func setns(ns *os.File) error {
+	_, _, errno := syscall.RawSyscall(sysSetns, ns.Fd(), syscall.CLONE_NEWNET, 0)
+	if errno != 0 {
+		return fmt.Errorf("setns: %w", errno)
+	}
+	return nil
+}
+
+// Pair is a pair of namespaces connected by a veth pair, with ServerIP
+// assigned to the server side and ClientIP to the client side, both in Subnet.
+type Pair struct {
+	Server, Client *Namespace
+
+	Subnet             string
+	ServerIP, ClientIP string
+	// Names of the veth devices in the server and client namespace.
+	ServerDev, ClientDev string
+}
+
+// NewPair creates a connected pair of namespaces with a random name prefix.
+func NewPair() (*Pair, error) {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	id := hex.EncodeToString(suffix)
+
+	p := &Pair{
+		Subnet:    "10.248.0.0/24",
+		ServerIP:  "10.248.0.1",
+		ClientIP:  "10.248.0.2",
+		ServerDev: "veth-srv",
+		ClientDev: "veth-cli",
+	}
+
+	var err error
+	if p.Server, err = New("tcd-srv-" + id); err != nil {
+		return nil, err
+	}
+	if p.Client, err = New("tcd-cli-" + id); err != nil {
+		p.Server.Close()
+		return nil, err
+	}
+
+	steps := [][]string{
+		{"link", "add", p.ServerDev, "netns", p.Server.Name, "type", "veth", "peer", "name", p.ClientDev, "netns", p.Client.Name},
+		{"-n", p.Server.Name, "addr", "add", p.ServerIP + "/24", "dev", p.ServerDev},
+		{"-n", p.Client.Name, "addr", "add", p.ClientIP + "/24", "dev", p.ClientDev},
+		{"-n", p.Server.Name, "link", "set", p.ServerDev, "up"},
+		{"-n", p.Client.Name, "link", "set", p.ClientDev, "up"},
+	}
+	for _, args := range steps {
+		if err := ip(args...); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Close deletes both namespaces, which also removes the veth pair.
+func (p *Pair) Close() error {
+	return errors.Join(p.Client.Close(), p.Server.Close())
+}
+
+func ip(args ...string) error {
+	return run("ip", args...)
+}
+
+func run(name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %v: %w: %s", name, args, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
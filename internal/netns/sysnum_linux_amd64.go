@@ -0,0 +1,4 @@
+package netns
+
+// The syscall package predates setns(2) and doesn't define its number.
+const sysSetns = 308
@@ -0,0 +1,49 @@
+package internal
+
+import "time"
+
+// Pacer spaces out iterations of a send loop to a target interval. It sleeps
+// outside of the Go scheduler and compensates for time spent writing to the
+// socket and for late wakeups.
+type Pacer struct {
+	interval time.Duration
+	pause    time.Duration
+	start    time.Time
+}
+
+// NewPacer returns a Pacer targeting the given interval.
+func NewPacer(interval time.Duration) *Pacer {
+	// Start off with the configured packet interval. This will be adjusted
+	// based on the time it took to write to the socket.
+	return &Pacer{interval: interval, pause: interval}
+}
+
+// Start marks the beginning of a loop iteration.
+func (p *Pacer) Start() {
+	p.start = time.Now()
+}
+
+// Wait sleeps until the next loop iteration is due.
+//
+// The calling goroutine should be locked to its OS thread using
+// [runtime.LockOSThread] to prevent the runtime from migrating and interrupting
+// it as often.
+func (p *Pacer) Wait() {
+	// Sleep for the duration determined during the previous round. Use a
+	// direct call to nanosleep(2) since the regular [time.Sleep] is
+	// implemented by the Go runtime and gets coalesced to reduce syscall
+	// overhead. This leads to wildly unexpected sleep durations.
+	Sleep(p.pause)
+
+	// Adjust the sleep interval for the next cycle based on the time it took
+	// to write to the socket and when the OS scheduler woke us up.
+	delta := p.interval - time.Since(p.start)
+
+	// Smoothen the approach to the target interval by adjusting the pause
+	// interval by half the delta.
+	p.pause += (delta / 2)
+
+	// Ensure pause stays within bounds. On a permanent deficit, it would
+	// run negative and overflow at some point.
+	p.pause = min(max(p.pause, -p.interval), p.interval)
+}
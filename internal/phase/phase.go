@@ -0,0 +1,191 @@
+// Package phase tracks the phases of a test, such as "pre-upgrade",
+// "agent-restarting" and "post-upgrade", as marked by an external
+// orchestrator through a signal, a control file or an HTTP call. Results are
+// broken down by phase so that behaviour before, during and after a
+// disruption can be compared.
+package phase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mark is the start of a phase.
+type Mark struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// Tracker tracks the current phase.
+type Tracker struct {
+	mu    sync.Mutex
+	marks []Mark
+	subs  []func(Mark)
+}
+
+// NewTracker returns a tracker starting out in the given phase.
+func NewTracker(initial string) *Tracker {
+	return &Tracker{marks: []Mark{{Name: initial, Time: time.Now()}}}
+}
+
+// Current returns the name of the current phase.
+func (t *Tracker) Current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.marks[len(t.marks)-1].Name
+}
+
+// Marks returns the start of every phase so far, in order. Phases may repeat.
+func (t *Tracker) Marks() []Mark {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Mark(nil), t.marks...)
+}
+
+// Set enters the given phase, unless it is the current one already.
+func (t *Tracker) Set(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("empty phase name")
+	}
+
+	t.mu.Lock()
+	if t.marks[len(t.marks)-1].Name == name {
+		t.mu.Unlock()
+		return nil
+	}
+	mark := Mark{Name: name, Time: time.Now()}
+	t.marks = append(t.marks, mark)
+	subs := t.subs
+	t.mu.Unlock()
+
+	for _, f := range subs {
+		f(mark)
+	}
+	return nil
+}
+
+// Subscribe calls f whenever a new phase is entered.
+func (t *Tracker) Subscribe(f func(Mark)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subs = append(t.subs, f)
+}
+
+// Span is a contiguous period of time spent in a phase.
+type Span struct {
+	Name       string
+	Start, End time.Time
+}
+
+// Spans returns the periods of time spent in each phase between start and
+// end, in order.
+func (t *Tracker) Spans(start, end time.Time) []Span {
+	marks := t.Marks()
+
+	var spans []Span
+	for i, m := range marks {
+		spanEnd := end
+		if i+1 < len(marks) {
+			spanEnd = marks[i+1].Time
+		}
+		s := Span{Name: m.Name, Start: m.Time, End: spanEnd}
+		if s.Start.Before(start) {
+			s.Start = start
+		}
+		if s.End.After(end) {
+			s.End = end
+		}
+		if s.End.After(s.Start) {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// NotifySignal advances through the given sequence of phases on every
+// receipt of sig, until ctx is cancelled. The tracker is expected to start
+// out in the first phase of the sequence.
+func (t *Tracker) NotifySignal(ctx context.Context, sig os.Signal, sequence []string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig)
+	defer signal.Stop(c)
+
+	for next := 1; ; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+		}
+		if next >= len(sequence) {
+			continue
+		}
+		t.Set(sequence[next])
+		next++
+	}
+}
+
+// WatchFile polls the file at path at the given interval and enters the
+// phase named by its content, until ctx is cancelled. A missing or empty file
+// leaves the phase unchanged.
+func (t *Tracker) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if data, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(data)) > 0 {
+			t.Set(string(data))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handler returns the handler of the HTTP control API:
+//
+//	GET  /phase    Return the current phase and all phase marks so far.
+//	POST /phase    Enter the phase named by the request body, or the name
+//	               query parameter.
+func (t *Tracker) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /phase", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Current string `json:"current"`
+			Marks   []Mark `json:"marks"`
+		}{t.Current(), t.Marks()})
+	})
+
+	mux.HandleFunc("POST /phase", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("read body: %s", err), http.StatusBadRequest)
+				return
+			}
+			name = string(body)
+		}
+		if err := t.Set(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
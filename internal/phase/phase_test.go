@@ -0,0 +1,219 @@
+package phase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// names returns the names of the tracker's marks, in order.
+func names(t *Tracker) []string {
+	var names []string
+	for _, m := range t.Marks() {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// waitPhase waits for the tracker to enter the given phase.
+func waitPhase(t *testing.T, tr *Tracker, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.Current() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected phase %q, got %q", want, tr.Current())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		set   []string
+		marks []string
+		err   bool
+	}{
+		{name: "transition", set: []string{"during", "post"}, marks: []string{"pre", "during", "post"}},
+		{name: "repeated", set: []string{"during", "during", "during"}, marks: []string{"pre", "during"}},
+		{name: "current", set: []string{"pre"}, marks: []string{"pre"}},
+		{name: "revisited", set: []string{"during", "pre"}, marks: []string{"pre", "during", "pre"}},
+		{name: "trimmed", set: []string{" during\n", "during"}, marks: []string{"pre", "during"}},
+		{name: "unknown", set: []string{"whatever it is"}, marks: []string{"pre", "whatever it is"}},
+		{name: "empty", set: []string{" \n"}, marks: []string{"pre"}, err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTracker("pre")
+			var entered []string
+			tr.Subscribe(func(m Mark) { entered = append(entered, m.Name) })
+
+			var err error
+			for _, name := range tt.set {
+				err = tr.Set(name)
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("expected error %t, got %v", tt.err, err)
+			}
+			if got := names(tr); !slices.Equal(got, tt.marks) {
+				t.Errorf("expected marks %q, got %q", tt.marks, got)
+			}
+			if got := tr.Current(); got != tt.marks[len(tt.marks)-1] {
+				t.Errorf("expected current phase %q, got %q", tt.marks[len(tt.marks)-1], got)
+			}
+			if !slices.Equal(entered, tt.marks[1:]) {
+				t.Errorf("expected subscribers to see %q, got %q", tt.marks[1:], entered)
+			}
+		})
+	}
+}
+
+func TestSpans(t *testing.T) {
+	base := time.Now()
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	tr := &Tracker{marks: []Mark{{"pre", at(0)}, {"during", at(10)}, {"post", at(20)}, {"during", at(30)}}}
+
+	for _, tt := range []struct {
+		name       string
+		start, end int
+		want       []Span
+	}{
+		{name: "all", start: 0, end: 40, want: []Span{
+			{"pre", at(0), at(10)}, {"during", at(10), at(20)}, {"post", at(20), at(30)}, {"during", at(30), at(40)},
+		}},
+		{name: "clipped", start: 5, end: 25, want: []Span{
+			{"pre", at(5), at(10)}, {"during", at(10), at(20)}, {"post", at(20), at(25)},
+		}},
+		{name: "within", start: 12, end: 18, want: []Span{{"during", at(12), at(18)}}},
+		{name: "boundary", start: 10, end: 20, want: []Span{{"during", at(10), at(20)}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tr.Spans(at(tt.start), at(tt.end)); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tr := NewTracker("pre")
+	srv := httptest.NewServer(tr.Handler())
+	defer srv.Close()
+
+	post := func(query, body string, want int) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/phase"+query, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("POST %q %q: expected status %d, got %d", query, body, want, resp.StatusCode)
+		}
+	}
+
+	post("", "during\n", http.StatusNoContent)
+	post("", "during", http.StatusNoContent)
+	post("?name=post", "ignored", http.StatusNoContent)
+	post("", "", http.StatusBadRequest)
+	post("?name=", " ", http.StatusBadRequest)
+
+	resp, err := http.Get(srv.URL + "/phase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got struct {
+		Current string `json:"current"`
+		Marks   []Mark `json:"marks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Current != "post" {
+		t.Errorf("expected current phase post, got %q", got.Current)
+	}
+	var marks []string
+	for _, m := range got.Marks {
+		marks = append(marks, m.Name)
+	}
+	if want := []string{"pre", "during", "post"}; !slices.Equal(marks, want) {
+		t.Errorf("expected marks %q, got %q", want, marks)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phase")
+	tr := NewTracker("pre")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.WatchFile(ctx, path, 10*time.Millisecond)
+
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A missing or empty file leaves the phase unchanged, and rewriting the
+	// current phase doesn't mark it again.
+	time.Sleep(50 * time.Millisecond)
+	write("  \n")
+	time.Sleep(50 * time.Millisecond)
+	write("during\n")
+	waitPhase(t, tr, "during")
+	write("during")
+	time.Sleep(50 * time.Millisecond)
+	write("post\n")
+	waitPhase(t, tr, "post")
+
+	if want, got := []string{"pre", "during", "post"}, names(tr); !slices.Equal(got, want) {
+		t.Errorf("expected marks %q, got %q", want, got)
+	}
+}
+
+func TestNotifySignal(t *testing.T) {
+	// Keep the signal from terminating the test before NotifySignal handles it.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
+
+	tr := NewTracker("pre")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.NotifySignal(ctx, syscall.SIGUSR1, []string{"pre", "during", "post"})
+
+	kill := func() {
+		t.Helper()
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Signal until NotifySignal is listening.
+	for tr.Current() == "pre" {
+		kill()
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := tr.Current(); got != "during" {
+		t.Fatalf("expected phase during, got %q", got)
+	}
+	kill()
+	waitPhase(t, tr, "post")
+
+	// Signals past the end of the sequence leave the last phase.
+	kill()
+	time.Sleep(50 * time.Millisecond)
+	if want, got := []string{"pre", "during", "post"}, names(tr); !slices.Equal(got, want) {
+		t.Errorf("expected marks %q, got %q", want, got)
+	}
+}
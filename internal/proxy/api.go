@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Handler returns the handler of the HTTP fault injection API:
+//
+//	GET    /faults                 List active faults and their remaining time.
+//	POST   /faults/{kind}          Inject a fault. Optional query parameters are
+//	                               duration (e.g. 2s, default until cleared) and
+//	                               delay for the delay fault.
+//	DELETE /faults[/{kind}]        Clear all faults or the given one.
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /faults", func(w http.ResponseWriter, r *http.Request) {
+		status := make(map[string]string)
+		for kind, remaining := range p.faults.status() {
+			status[kind] = "until cleared"
+			if remaining > 0 {
+				status[kind] = remaining.String()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	mux.HandleFunc("POST /faults/{kind}", func(w http.ResponseWriter, r *http.Request) {
+		var duration, delay time.Duration
+		for name, d := range map[string]*time.Duration{"duration": &duration, "delay": &delay} {
+			v := r.URL.Query().Get(name)
+			if v == "" {
+				continue
+			}
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %s", name, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := p.Inject(r.PathValue("kind"), duration, delay); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	clearFaults := func(w http.ResponseWriter, r *http.Request) {
+		kind := r.PathValue("kind")
+		p.faults.clear(kind)
+		if kind == "" {
+			p.log.Info("Cleared all faults")
+		} else {
+			p.log.Info("Cleared fault", "fault", kind)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	mux.HandleFunc("DELETE /faults", clearFaults)
+	mux.HandleFunc("DELETE /faults/{kind}", clearFaults)
+
+	return mux
+}
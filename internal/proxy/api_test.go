@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cilium/test-connection-disruption/internal/testutil"
+)
+
+func TestAPI(t *testing.T) {
+	p, err := Listen(Config{Protocol: "tcp", Target: "127.0.0.1:1", Log: testutil.Logger(t)}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.listener.Close()
+
+	srv := httptest.NewServer(p.Handler())
+	defer srv.Close()
+
+	do := func(method, path string, want int) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected status %d, got %d", method, path, want, resp.StatusCode)
+		}
+	}
+	status := func() map[string]string {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/faults")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	do(http.MethodPost, "/faults/pause", http.StatusNoContent)
+	do(http.MethodPost, "/faults/delay?delay=100ms&duration=1m", http.StatusNoContent)
+	do(http.MethodPost, "/faults/reset", http.StatusNoContent)
+	for _, path := range []string{"/faults/unknown", "/faults/delay", "/faults/delay?delay=soon", "/faults/pause?duration=-"} {
+		do(http.MethodPost, path, http.StatusBadRequest)
+	}
+
+	got := status()
+	if len(got) != 2 || got["pause"] != "until cleared" || got["delay"] == "" || got["delay"] == "until cleared" {
+		t.Fatalf("expected pause until cleared and delay for a minute, got %v", got)
+	}
+	if !p.faults.isActive(faultPause) {
+		t.Fatal("expected the proxy to be paused")
+	}
+
+	do(http.MethodDelete, "/faults/pause", http.StatusNoContent)
+	if got := status(); len(got) != 1 || got["delay"] == "" {
+		t.Fatalf("expected only the delay left, got %v", got)
+	}
+	do(http.MethodDelete, "/faults", http.StatusNoContent)
+	if got := status(); len(got) != 0 {
+		t.Fatalf("expected no faults left, got %v", got)
+	}
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fault kinds the proxy can inject.
+const (
+	// faultDelay holds back every chunk of data by a configurable delay from
+	// when it was received.
+	faultDelay = "delay"
+	// faultBlackhole silently discards all data.
+	faultBlackhole = "blackhole"
+	// faultPause stops forwarding without discarding data. Senders are pushed
+	// back on by flow control and forwarding resumes once the fault ends.
+	faultPause = "pause"
+	// faultDuplicate forwards every chunk of data twice.
+	faultDuplicate = "duplicate"
+	// faultCorrupt flips random bits of a random byte in every chunk of data.
+	faultCorrupt = "corrupt"
+	// faultReset resets all connections when injected. It has no duration.
+	faultReset = "reset"
+)
+
+// FaultKinds are the faults the proxy can inject.
+var FaultKinds = []string{faultDelay, faultBlackhole, faultPause, faultDuplicate, faultCorrupt, faultReset}
+
+// faults tracks the faults that are currently active.
+type faults struct {
+	mu sync.Mutex
+	// Active faults mapped to the time they end. A zero time means the fault
+	// stays active until cleared.
+	active map[string]time.Time
+	delay  time.Duration
+}
+
+func newFaults() *faults {
+	return &faults{active: make(map[string]time.Time)}
+}
+
+// inject activates a fault for the given duration, or until cleared if
+// duration is zero. delay is only used by faultDelay.
+func (f *faults) inject(kind string, duration, delay time.Duration) error {
+	if !slices.Contains(FaultKinds, kind) {
+		return fmt.Errorf("unknown fault %q, must be one of %v", kind, FaultKinds)
+	}
+	if kind == faultReset {
+		return fmt.Errorf("%s is an action, not a fault with a duration", kind)
+	}
+	if kind == faultDelay && delay <= 0 {
+		return fmt.Errorf("%s requires a positive delay", kind)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	f.active[kind] = until
+	if kind == faultDelay {
+		f.delay = delay
+	}
+
+	return nil
+}
+
+// clear deactivates the given fault, or all faults if kind is empty.
+func (f *faults) clear(kind string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if kind == "" {
+		clear(f.active)
+		return
+	}
+	delete(f.active, kind)
+}
+
+// isActive returns true if the given fault is active.
+func (f *faults) isActive(kind string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.isActiveLocked(kind)
+}
+
+func (f *faults) isActiveLocked(kind string) bool {
+	until, ok := f.active[kind]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(f.active, kind)
+		return false
+	}
+	return true
+}
+
+// status returns the active faults and the remaining time until they end,
+// zero meaning they stay active until cleared.
+func (f *faults) status() map[string]time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := make(map[string]time.Duration)
+	for kind, until := range f.active {
+		if !f.isActiveLocked(kind) {
+			continue
+		}
+		var remaining time.Duration
+		if !until.IsZero() {
+			remaining = time.Until(until).Round(time.Millisecond)
+		}
+		status[kind] = remaining
+	}
+	return status
+}
+
+// waitPaused blocks while forwarding is paused.
+func (f *faults) waitPaused() {
+	// Poll instead of waiting for a signal to keep things simple, the added
+	// latency doesn't matter here.
+	for f.isActive(faultPause) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// apply runs a chunk of data through the active faults and returns the chunks
+// to forward in its place, along with how long to hold them back. The
+// returned chunks may alias data.
+func (f *faults) apply(data []byte) ([][]byte, time.Duration) {
+	f.mu.Lock()
+	blackhole := f.isActiveLocked(faultBlackhole)
+	duplicate := f.isActiveLocked(faultDuplicate)
+	corrupt := f.isActiveLocked(faultCorrupt)
+	var delay time.Duration
+	if f.isActiveLocked(faultDelay) {
+		delay = f.delay
+	}
+	f.mu.Unlock()
+
+	if blackhole {
+		return nil, 0
+	}
+
+	if corrupt && len(data) > 0 {
+		// Pick the byte at random, as the same fixed change applied to both
+		// directions would cancel out in echoed data.
+		data[rand.IntN(len(data))] ^= byte(rand.IntN(255) + 1)
+	}
+
+	if duplicate {
+		return [][]byte{data, data}, delay
+	}
+	return [][]byte{data}, delay
+}
+
+// chunk is a chunk of data queued for forwarding once due.
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// delayLine forwards the chunks of data of one direction of a flow, in order,
+// once they're due. Chunks are delayed from when they were received, so a
+// delay holds back a stream by the delay once rather than once per chunk, and
+// delaying one flow doesn't hold back the others.
+type delayLine struct {
+	faults *faults
+	queue  chan chunk
+}
+
+// newDelayLine returns a delay line queueing up to size chunks.
+func newDelayLine(f *faults, size int) *delayLine {
+	return &delayLine{faults: f, queue: make(chan chunk, size)}
+}
+
+// push runs data received at the given time through the active faults and
+// queues the resulting chunks. When the queue is full, push blocks with wait
+// to push back on the sender, and otherwise drops the chunks like a router
+// with a full queue. data may be reused once push returns.
+func (l *delayLine) push(data []byte, received time.Time, wait bool) {
+	chunks, delay := l.faults.apply(data)
+	for _, data := range chunks {
+		c := chunk{data: bytes.Clone(data), due: received.Add(delay)}
+		if wait {
+			l.queue <- c
+			continue
+		}
+		select {
+		case l.queue <- c:
+		default:
+		}
+	}
+}
+
+// close closes the line once the chunks queued so far are forwarded. Chunks
+// must not be pushed afterwards.
+func (l *delayLine) close() {
+	close(l.queue)
+}
+
+// run passes queued chunks to write once they're due, holding on to them
+// while forwarding is paused, until the line is closed. After write fails, the
+// remaining chunks are discarded and its error is returned once the line is
+// closed.
+func (l *delayLine) run(write func([]byte) error) error {
+	for c := range l.queue {
+		l.faults.waitPaused()
+		time.Sleep(time.Until(c.due))
+		if err := write(c.data); err != nil {
+			for range l.queue {
+			}
+			return err
+		}
+	}
+	return nil
+}
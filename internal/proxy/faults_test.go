@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayLine(t *testing.T) {
+	f := newFaults()
+	if err := f.inject(faultDelay, 0, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	line := newDelayLine(f, 16)
+	start := time.Now()
+	done := make(chan []time.Duration)
+	go func() {
+		var delays []time.Duration
+		line.run(func([]byte) error {
+			delays = append(delays, time.Since(start))
+			return nil
+		})
+		done <- delays
+	}()
+
+	buf := make([]byte, 1)
+	for i := range 10 {
+		buf[0] = byte(i)
+		line.push(buf, time.Now(), true)
+	}
+	line.close()
+
+	delays := <-done
+	if len(delays) != 10 {
+		t.Fatalf("expected 10 chunks, got %d", len(delays))
+	}
+	// Every chunk is delayed from when it was received, not from the previous
+	// one.
+	for i, d := range delays {
+		if d < 100*time.Millisecond || d > 500*time.Millisecond {
+			t.Errorf("chunk %d forwarded after %s, expected about 100ms", i, d)
+		}
+	}
+}
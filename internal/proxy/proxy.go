@@ -0,0 +1,361 @@
+// Package proxy implements a TCP and UDP proxy that injects faults into the
+// traffic it forwards, to validate that clients detect them.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// Protocols the proxy can forward.
+var Protocols = []string{"tcp", "udp"}
+
+// Config configures a Proxy.
+type Config struct {
+	// Protocol is one of [Protocols].
+	Protocol string
+	// Target is the address traffic is forwarded to.
+	Target string
+
+	// Log receives progress messages and errors of individual connections.
+	// Defaults to [internal.DefaultLogger].
+	Log *slog.Logger
+}
+
+// Proxy forwards traffic between clients and the target, injecting faults
+// along the way.
+type Proxy struct {
+	cfg    Config
+	log    *slog.Logger
+	faults *faults
+
+	// Exactly one of them is set, depending on the protocol.
+	listener net.Listener
+	packet   net.PacketConn
+
+	mu sync.Mutex
+	// All open connections on both sides of the proxy, for resetting them.
+	conns map[net.Conn]struct{}
+}
+
+// Listen returns a proxy listening on addr.
+func Listen(cfg Config, addr string) (*Proxy, error) {
+	p := &Proxy{
+		cfg:    cfg,
+		log:    cfg.Log,
+		faults: newFaults(),
+		conns:  make(map[net.Conn]struct{}),
+	}
+	if p.log == nil {
+		p.log = internal.DefaultLogger()
+	}
+
+	var err error
+	switch cfg.Protocol {
+	case "tcp":
+		p.listener, err = net.Listen("tcp", addr)
+	case "udp":
+		p.packet, err = net.ListenPacket("udp", addr)
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", cfg.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on.
+func (p *Proxy) Addr() net.Addr {
+	if p.listener != nil {
+		return p.listener.Addr()
+	}
+	return p.packet.LocalAddr()
+}
+
+// Serve forwards traffic until ctx is cancelled.
+func (p *Proxy) Serve(ctx context.Context) error {
+	p.log.Info("Proxying", "protocol", p.cfg.Protocol, "addr", p.Addr(), "upstream", p.cfg.Target)
+
+	if p.listener != nil {
+		context.AfterFunc(ctx, func() { p.listener.Close() })
+		return p.serveTCP(ctx, p.listener)
+	}
+	context.AfterFunc(ctx, func() { p.packet.Close() })
+	return p.serveUDP(p.packet)
+}
+
+// Schedule injects a fault after the given delay.
+func (p *Proxy) Schedule(ctx context.Context, kind string, start, duration, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(start):
+	}
+
+	if err := p.Inject(kind, duration, delay); err != nil {
+		p.log.Error("Error injecting scheduled fault", "fault", kind, "error", err)
+	}
+}
+
+// Inject activates a fault for the given duration, or until cleared if
+// duration is zero. delay is only used by the delay fault. The reset fault
+// resets all connections instead.
+func (p *Proxy) Inject(kind string, duration, delay time.Duration) error {
+	if kind == faultReset {
+		p.log.Warn("Reset connections", "connections", p.reset())
+		return nil
+	}
+
+	if err := p.faults.inject(kind, duration, delay); err != nil {
+		return err
+	}
+
+	if duration > 0 {
+		p.log.Warn("Injecting fault", "fault", kind, "duration", duration)
+	} else {
+		p.log.Warn("Injecting fault until cleared", "fault", kind)
+	}
+	return nil
+}
+
+func (p *Proxy) track(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conns[conn] = struct{}{}
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// Connections returns the number of open connections on both sides of the
+// proxy.
+func (p *Proxy) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// reset closes all connections with a RST and returns their number.
+func (p *Proxy) reset() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A zero linger time makes the kernel send a RST instead of a FIN. Set it
+	// on every connection first, as closing one side makes its pipe close the
+	// other.
+	for conn := range p.conns {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return len(p.conns)
+}
+
+func (p *Proxy) serveTCP(ctx context.Context, listen net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listen.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			p.log.Info("Listener closed")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("accept conn: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.forwardTCP(ctx, conn)
+		}()
+	}
+}
+
+func (p *Proxy) forwardTCP(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	upstream, err := net.Dial("tcp", p.cfg.Target)
+	if err != nil {
+		p.log.Error("Error connecting to target", internal.ConnAttrs(conn), "upstream", p.cfg.Target, "error", err)
+		return
+	}
+	defer upstream.Close()
+
+	p.log.Info("Forwarding", internal.ConnAttrs(conn), "upstream", upstream.RemoteAddr())
+
+	p.track(conn)
+	defer p.untrack(conn)
+	p.track(upstream)
+	defer p.untrack(upstream)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.pipe(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		p.pipe(conn, upstream)
+	}()
+	wg.Wait()
+
+	p.log.Info("Connection closed", internal.ConnAttrs(conn))
+}
+
+// Number of chunks of data queued in each direction of a TCP connection and
+// of datagrams in each direction of a UDP flow, see [delayLine]. Reading from a
+// TCP connection stops while its queue is full, while datagrams are dropped.
+const (
+	tcpQueueSize = 64
+	udpQueueSize = 4096
+)
+
+// pipe copies data from src to dst until src is closed, then half-closes dst
+// to pass on the end of the stream. If src is closed or reset instead, dst is
+// reset as well.
+func (p *Proxy) pipe(dst, src net.Conn) {
+	line := newDelayLine(p.faults, tcpQueueSize)
+	// Set before closing the line, and read once it has been drained.
+	var eof bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := line.run(func(data []byte) error {
+			_, err := dst.Write(data)
+			if err != nil {
+				// Unblock the reader.
+				src.Close()
+			}
+			return err
+		})
+		switch {
+		case err != nil:
+		case eof:
+			internal.CloseWrite(dst)
+		default:
+			// Pass on the reset rather than the end of the stream.
+			if tcp, ok := dst.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			dst.Close()
+		}
+	}()
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			line.push(buf[:n], time.Now(), true)
+		}
+		if err != nil {
+			eof = errors.Is(err, io.EOF)
+			line.close()
+			<-done
+			return
+		}
+	}
+}
+
+// udpIdleTimeout is the time after which UDP flows without traffic are
+// forgotten.
+const udpIdleTimeout = time.Minute
+
+func (p *Proxy) serveUDP(listen net.PacketConn) error {
+	target, err := net.ResolveUDPAddr("udp", p.cfg.Target)
+	if err != nil {
+		return fmt.Errorf("resolve target: %w", err)
+	}
+
+	// Flows by client address, with the delay lines forwarding the clients'
+	// datagrams to the target.
+	var mu sync.Mutex
+	flows := make(map[string]*delayLine)
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := listen.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			p.log.Info("Listener closed")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read datagram: %w", err)
+		}
+
+		received := time.Now()
+
+		mu.Lock()
+		up, ok := flows[addr.String()]
+		if !ok {
+			upstream, err := net.DialUDP("udp", nil, target)
+			if err != nil {
+				mu.Unlock()
+				p.log.Error("Error connecting to target", internal.LogRemote, addr, "upstream", target, "error", err)
+				continue
+			}
+			up = newDelayLine(p.faults, udpQueueSize)
+			flows[addr.String()] = up
+			p.log.Info("Forwarding", internal.LogRemote, addr, "upstream", target)
+
+			go up.run(func(dgram []byte) error {
+				upstream.Write(dgram)
+				return nil
+			})
+
+			// Relay replies back to the client until the flow goes idle.
+			go func() {
+				down := newDelayLine(p.faults, udpQueueSize)
+				go down.run(func(dgram []byte) error {
+					listen.WriteTo(dgram, addr)
+					return nil
+				})
+
+				defer func() {
+					mu.Lock()
+					delete(flows, addr.String())
+					up.close()
+					mu.Unlock()
+					down.close()
+					upstream.Close()
+				}()
+
+				reply := make([]byte, 64<<10)
+				for {
+					upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := upstream.Read(reply)
+					if err != nil {
+						return
+					}
+					down.push(reply[:n], time.Now(), false)
+				}
+			}()
+		}
+		// Push while holding the lock, so the flow can't be closed in between.
+		up.push(buf[:n], received, false)
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY protocol support as specified in
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// Maximum length of a v1 header including the trailing CRLF.
+	proxyV1MaxLen = 107
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamilyInet  = 0x1
+	proxyV2FamilyInet6 = 0x2
+	proxyV2ProtoStream = 0x1
+)
+
+// ProxyConn is a connection that was preceded by a PROXY protocol header. Its
+// RemoteAddr is the original source address conveyed in the header.
+type ProxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the original source address of the connection, or the
+// address of the proxy if the header didn't convey one.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ProxyAddr returns the address of the proxy the connection came through.
+func (c *ProxyConn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite shuts down the writing side of the underlying connection.
+func (c *ProxyConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header from conn and returns
+// a connection reporting the original source address as its RemoteAddr. Fails
+// if no header is received within timeout, unless it is zero.
+func ReadProxyHeader(conn net.Conn, timeout time.Duration) (*ProxyConn, error) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, fmt.Errorf("set read deadline: %w", err)
+		}
+	}
+
+	pc := &ProxyConn{Conn: conn, r: bufio.NewReader(conn), remote: conn.RemoteAddr()}
+
+	// The shortest valid header is "PROXY UNKNOWN\r\n", so peeking at the
+	// length of the v2 signature would block for short v1 headers.
+	prefix, err := pc.r.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	var src net.Addr
+	switch {
+	case string(prefix) == "PROXY":
+		src, err = readProxyV1(pc.r)
+	case bytes.HasPrefix(proxyV2Signature, prefix):
+		src, err = readProxyV2(pc.r)
+	default:
+		return nil, fmt.Errorf("no PROXY protocol header found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		pc.remote = src
+	}
+
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, fmt.Errorf("clear read deadline: %w", err)
+		}
+	}
+
+	return pc, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, fmt.Errorf("v1 header exceeds %d bytes", proxyV1MaxLen)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed v1 header %q", line)
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address %q in v1 header", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port %q in v1 header", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("read v2 header: %w", err)
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, errors.New("invalid v2 signature")
+	}
+	if version := hdr[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported v2 header version %d", version)
+	}
+
+	// Read the addresses and any TLVs following them, even if we ignore them,
+	// so they aren't mistaken for data.
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("read v2 addresses: %w", err)
+	}
+
+	switch cmd := hdr[12] & 0xf; cmd {
+	case proxyV2CmdLocal:
+		// Health checks and the like sent by the proxy itself.
+		return nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", cmd)
+	}
+
+	switch family := hdr[13] >> 4; family {
+	case proxyV2FamilyInet:
+		if len(body) < 12 {
+			return nil, errors.New("short v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case proxyV2FamilyInet6:
+		if len(body) < 36 {
+			return nil, errors.New("short v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		// Unix sockets and unspecified families carry no usable address.
+		return nil, nil
+	}
+}
+
+// WriteProxyHeader writes a PROXY protocol header of the given version (1 or
+// 2) to conn, conveying its local and remote address. As v1 headers can't
+// mix address families, they convey no address if the two differ.
+func WriteProxyHeader(conn net.Conn, version int) error {
+	src, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unsupported local address %s", conn.LocalAddr())
+	}
+	dst, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unsupported remote address %s", conn.RemoteAddr())
+	}
+
+	var hdr []byte
+	switch version {
+	case 1:
+		src4, dst4 := src.IP.To4() != nil, dst.IP.To4() != nil
+		switch {
+		case src4 != dst4:
+			hdr = []byte("PROXY UNKNOWN\r\n")
+		case src4:
+			hdr = fmt.Appendf(nil, "PROXY TCP4 %s %s %d %d\r\n", src.IP, dst.IP, src.Port, dst.Port)
+		default:
+			hdr = fmt.Appendf(nil, "PROXY TCP6 %s %s %d %d\r\n", src.IP, dst.IP, src.Port, dst.Port)
+		}
+	case 2:
+		hdr = append(hdr, proxyV2Signature...)
+		hdr = append(hdr, 2<<4|proxyV2CmdProxy)
+		if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+			hdr = append(hdr, proxyV2FamilyInet<<4|proxyV2ProtoStream)
+			hdr = binary.BigEndian.AppendUint16(hdr, 12)
+			hdr = append(hdr, src4...)
+			hdr = append(hdr, dst4...)
+		} else {
+			hdr = append(hdr, proxyV2FamilyInet6<<4|proxyV2ProtoStream)
+			hdr = binary.BigEndian.AppendUint16(hdr, 36)
+			hdr = append(hdr, src.IP.To16()...)
+			hdr = append(hdr, dst.IP.To16()...)
+		}
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(src.Port))
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(dst.Port))
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+
+	if _, err := conn.Write(hdr); err != nil {
+		return fmt.Errorf("write PROXY header: %w", err)
+	}
+	return nil
+}
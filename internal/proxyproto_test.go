@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyHeader(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		for _, network := range []string{"tcp4", "tcp6"} {
+			t.Run(fmt.Sprintf("v%d/%s", version, network), func(t *testing.T) {
+				l, err := net.Listen(network, "localhost:0")
+				if err != nil {
+					t.Skip(err)
+				}
+				defer l.Close()
+
+				client, err := net.Dial(network, l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+
+				if err := WriteProxyHeader(client, version); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.Write([]byte("data")); err != nil {
+					t.Fatal(err)
+				}
+
+				conn, err := l.Accept()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+
+				pconn, err := ReadProxyHeader(conn, time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := pconn.RemoteAddr().String(), client.LocalAddr().String(); got != want {
+					t.Fatalf("expected remote address %s, got %s", want, got)
+				}
+
+				// Data following the header must be left untouched.
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(pconn, buf); err != nil {
+					t.Fatal(err)
+				}
+				if string(buf) != "data" {
+					t.Fatalf("expected data after header, got %q", buf)
+				}
+			})
+		}
+	}
+}
+
+// addrConn overrides the addresses of a connection.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestProxyHeaderMixedFamilies(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5678}
+
+	for _, tt := range []struct {
+		version int
+		want    string
+	}{
+		{1, "PROXY UNKNOWN\r\n"},
+		// v2 conveys IPv4 addresses mapped to IPv6 instead.
+		{2, string(proxyV2Signature) + "\x21\x21\x00\x24"},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			defer client.Close()
+			if err := WriteProxyHeader(addrConn{client, v4, v6}, tt.version); err != nil {
+				t.Error(err)
+			}
+		}()
+		hdr, err := io.ReadAll(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(hdr[:min(len(hdr), len(tt.want))]); got != tt.want {
+			t.Errorf("v%d: expected header starting with %q, got %q", tt.version, tt.want, hdr)
+		}
+	}
+}
+
+func TestProxyHeaderNoTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Without a timeout, a header arriving late is fine.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 1234 80\r\n"))
+	}()
+
+	pconn, err := ReadProxyHeader(server, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pconn.RemoteAddr().String(), "192.0.2.1:1234"; got != want {
+		t.Fatalf("expected remote address %s, got %s", want, got)
+	}
+}
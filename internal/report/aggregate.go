@@ -0,0 +1,99 @@
+package report
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Verdicts of an aggregated report.
+const (
+	VerdictPass = "pass"
+	VerdictFail = "fail"
+	// VerdictIncomplete means no flow failed, but fewer clients than expected
+	// reported their results.
+	VerdictIncomplete = "incomplete"
+)
+
+// Report aggregates the flows of many clients.
+type Report struct {
+	Verdict         string `json:"verdict"`
+	Clients         int    `json:"clients"`
+	ExpectedClients int    `json:"expected_clients,omitempty"`
+	Flows           int    `json:"flows"`
+	FailedFlows     int    `json:"failed_flows"`
+
+	// MaxGapSeconds is the longest gap of any flow, and MaxGapFlow the ID of
+	// that flow.
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+	MaxGapFlow    string  `json:"max_gap_flow,omitempty"`
+
+	// Results holds all flows, ordered by client and name.
+	Results Flows `json:"results"`
+}
+
+// Aggregate builds a report from the flows of each client. expected is the
+// number of clients that must report for the verdict to pass, 0 if unknown.
+func Aggregate(clients map[string]Flows, expected int) Report {
+	r := Report{Verdict: VerdictPass, Clients: len(clients), ExpectedClients: expected}
+
+	for _, client := range slices.Sorted(maps.Keys(clients)) {
+		for _, f := range clients[client] {
+			f.Client = client
+			r.Results = append(r.Results, f)
+		}
+	}
+	slices.SortStableFunc(r.Results, func(a, b Flow) int { return strings.Compare(a.ID(), b.ID()) })
+
+	for _, f := range r.Results {
+		r.Flows++
+		if !f.OK {
+			r.FailedFlows++
+		}
+		if f.MaxGapSeconds > r.MaxGapSeconds {
+			r.MaxGapSeconds, r.MaxGapFlow = f.MaxGapSeconds, f.ID()
+		}
+	}
+
+	switch {
+	case r.FailedFlows > 0:
+		r.Verdict = VerdictFail
+	case r.Clients < expected:
+		r.Verdict = VerdictIncomplete
+	}
+	return r
+}
+
+// Failed returns the flows that failed.
+func (r Report) Failed() Flows {
+	var failed Flows
+	for _, f := range r.Results {
+		if !f.OK {
+			failed = append(failed, f)
+		}
+	}
+	return failed
+}
+
+// Summary returns the verdict and key figures on a single line.
+func (r Report) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d of %d flows failed across %d clients", strings.ToUpper(r.Verdict), r.FailedFlows, r.Flows, r.Clients)
+	if r.ExpectedClients > 0 {
+		fmt.Fprintf(&b, " (%d expected)", r.ExpectedClients)
+	}
+	if r.MaxGapFlow != "" {
+		fmt.Fprintf(&b, ", max gap %s (%s)", Seconds(r.MaxGapSeconds), r.MaxGapFlow)
+	}
+	return b.String()
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	b.WriteString(r.Summary())
+	for _, f := range r.Failed() {
+		fmt.Fprintf(&b, "\n  %s: %s", f.ID(), f.Error)
+	}
+	return b.String()
+}
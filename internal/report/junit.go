@@ -0,0 +1,136 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JUnit XML elements, as rendered by CI systems.
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Time     float64     `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      float64       `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// WriteJUnit writes the report as JUnit XML, with a test suite per client
+// named after the client, or name if the flows have none. Every flow is a
+// test case, and so is every threshold of a flow. A flow that only failed
+// because it violated a threshold leaves the failure to the threshold's test
+// case, so that it's counted once.
+func (r Report) WriteJUnit(w io.Writer, name string) error {
+	suites := junitSuites{Name: name}
+	for _, f := range r.Results {
+		suiteName := name
+		if f.Client != "" {
+			suiteName = f.Client
+		}
+		if len(suites.Suites) == 0 || suites.Suites[len(suites.Suites)-1].Name != suiteName {
+			suites.Suites = append(suites.Suites, junitSuite{Name: suiteName})
+		}
+		suite := &suites.Suites[len(suites.Suites)-1]
+
+		flow := junitCase{
+			Name:      f.Name,
+			Classname: suiteName,
+			Time:      f.DurationSeconds,
+			SystemOut: flowDetails(f),
+		}
+		if !f.OK && !thresholdFailure(f) {
+			flow.Failure = &junitFailure{Message: f.Error, Type: f.Failure, Text: f.Error}
+		}
+		suite.Cases = append(suite.Cases, flow)
+
+		for _, t := range f.Thresholds {
+			c := junitCase{
+				Name:      fmt.Sprintf("%s threshold %s", f.Name, t.Name),
+				Classname: suiteName,
+				SystemOut: fmt.Sprintf("%s: %s, limit %s\n", t.Name, t.Value, t.Limit),
+			}
+			if !t.OK {
+				msg := fmt.Sprintf("threshold %s violated: %s, limit %s", t.Name, t.Value, t.Limit)
+				c.Failure = &junitFailure{Message: msg, Type: "threshold", Text: msg}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+	}
+
+	for i := range suites.Suites {
+		s := &suites.Suites[i]
+		for _, c := range s.Cases {
+			s.Tests++
+			// Flows run concurrently.
+			s.Time = max(s.Time, c.Time)
+			if c.Failure != nil {
+				s.Failures++
+			}
+		}
+		suites.Tests += s.Tests
+		suites.Failures += s.Failures
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// thresholdFailure returns whether the flow failed because of one of its
+// thresholds, which has a test case of its own.
+func thresholdFailure(f Flow) bool {
+	if f.Threshold == "" {
+		return false
+	}
+	for _, t := range f.Thresholds {
+		if t.Name == f.Threshold && !t.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// flowDetails describes the flow and lists its stalls.
+func flowDetails(f Flow) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, f)
+	if f.Stalls > 0 {
+		fmt.Fprintf(&b, "%d stalls totalling %s:\n", f.Stalls, Seconds(f.StalledSeconds))
+		for _, s := range f.StallList {
+			fmt.Fprintln(&b, "  "+s.String())
+		}
+		if n := f.Stalls - len(f.StallList); n > 0 {
+			fmt.Fprintf(&b, "  and %d more\n", n)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxMarkdownStalls bounds the stalls listed per flow in Markdown, to keep the
+// summary short.
+const maxMarkdownStalls = 10
+
+// WriteMarkdown writes a short summary of the report as Markdown, with a
+// table of all flows, the thresholds that were violated and the first stalls
+// of every flow.
+func (r Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "**%s**\n\n", r.Summary())
+
+	b.WriteString("| Flow | Result | Sent | Received | Longest gap | Stalls | Error |\n")
+	b.WriteString("|------|--------|-----:|---------:|------------:|-------:|-------|\n")
+	for _, f := range r.Results {
+		result := "pass"
+		if !f.OK {
+			result = "**fail**"
+			if f.Failure != "" {
+				result += " (" + f.Failure + ")"
+			}
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %s | %d (%s) | %s |\n",
+			markdownCell(f.ID()), result, f.Sent, f.Received, Seconds(f.MaxGapSeconds),
+			f.Stalls, Seconds(f.StalledSeconds), markdownCell(f.Error))
+	}
+
+	var violated []string
+	for _, f := range r.Results {
+		for _, t := range f.Thresholds {
+			if !t.OK {
+				violated = append(violated, fmt.Sprintf("| %s | %s | %s | %s |\n",
+					markdownCell(f.ID()), t.Name, markdownCell(t.Limit), markdownCell(t.Value)))
+			}
+		}
+	}
+	if len(violated) > 0 {
+		b.WriteString("\n### Violated thresholds\n\n")
+		b.WriteString("| Flow | Threshold | Limit | Value |\n")
+		b.WriteString("|------|-----------|-------|-------|\n")
+		b.WriteString(strings.Join(violated, ""))
+	}
+
+	header := false
+	for _, f := range r.Results {
+		if len(f.StallList) == 0 {
+			continue
+		}
+		if !header {
+			b.WriteString("\n### Stalls\n")
+			header = true
+		}
+		fmt.Fprintf(&b, "\n%s:\n", markdownCell(f.ID()))
+		for i, s := range f.StallList {
+			if i == maxMarkdownStalls {
+				fmt.Fprintf(&b, "- and %d more\n", f.Stalls-maxMarkdownStalls)
+				break
+			}
+			fmt.Fprintf(&b, "- %s\n", s)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes s for use in a table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,195 @@
+// Package report holds the results of client runs and aggregates them across
+// flows and clients into a single verdict.
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Flow is the result of a single client target.
+type Flow struct {
+	// Client identifies the client that ran the flow, when aggregating the
+	// flows of several clients.
+	Client string `json:"client,omitempty"`
+	// Name is the label of the target.
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Failure is the kind of error the flow failed with, and Threshold names
+	// the threshold it violated, if that's why.
+	Failure   string `json:"failure,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+
+	Sent            uint64  `json:"sent"`
+	Received        uint64  `json:"received"`
+	MaxGapSeconds   float64 `json:"max_gap_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Stalls is the number of gaps longer than the stall threshold, and
+	// StalledSeconds their total duration. StallList holds the first of them.
+	Stalls         int     `json:"stalls"`
+	StalledSeconds float64 `json:"stalled_seconds"`
+	StallList      []Stall `json:"stall_list,omitempty"`
+
+	// MissedSlots is the number of send slots the client didn't meet in rate
+	// mode, and DroppedSlots those it didn't send at all.
+	MissedSlots  uint64 `json:"missed_slots,omitempty"`
+	DroppedSlots uint64 `json:"dropped_slots,omitempty"`
+
+	// SendRate is the rate the client sent requests at, TargetRate the one it
+	// aimed for and SendJitterSeconds the standard deviation of the intervals
+	// between requests. ClientBoundSeconds counts the seconds the client
+	// itself, rather than the network, kept it from meeting the target rate.
+	SendRate           float64 `json:"send_rate,omitempty"`
+	TargetRate         float64 `json:"target_rate,omitempty"`
+	SendJitterSeconds  float64 `json:"send_jitter_seconds,omitempty"`
+	ClientBoundSeconds int     `json:"client_bound_seconds,omitempty"`
+
+	// UpstreamDelaySeconds and DownstreamDelaySeconds are the average delays
+	// from client to server and back, and ClockOffsetSeconds the estimated
+	// offset of the server's clock, if the client measured one-way delays.
+	UpstreamDelaySeconds   float64 `json:"upstream_delay_seconds,omitempty"`
+	DownstreamDelaySeconds float64 `json:"downstream_delay_seconds,omitempty"`
+	ClockOffsetSeconds     float64 `json:"clock_offset_seconds,omitempty"`
+
+	// Directions breaks down the messages by direction, see [Direction].
+	Directions []Direction `json:"directions,omitempty"`
+
+	// Thresholds holds the outcome of every threshold configured for the flow.
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+
+	// Phases breaks down the results by phase of the test, if tracked.
+	Phases []Phase `json:"phases,omitempty"`
+}
+
+// Stall is a gap in the messages received by a flow.
+type Stall struct {
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Phase           string    `json:"phase,omitempty"`
+	// Direction is the direction the stall occurred in, if the client
+	// measured one-way delays.
+	Direction string `json:"direction,omitempty"`
+	// ICMPSent and ICMPReceived are the ICMP echo requests sent during the
+	// stall and the replies received, if the client probed with ICMP.
+	ICMPSent     uint64 `json:"icmp_sent,omitempty"`
+	ICMPReceived uint64 `json:"icmp_received,omitempty"`
+}
+
+func (s Stall) String() string {
+	line := fmt.Sprintf("%s for %s", s.Start.UTC().Format(time.RFC3339Nano), Seconds(s.DurationSeconds))
+	if s.Direction != "" {
+		line += " " + s.Direction
+	}
+	if s.Phase != "" {
+		line += " in phase " + s.Phase
+	}
+	if s.ICMPSent > 0 {
+		line += fmt.Sprintf(", %d of %d ICMP echo replies", s.ICMPReceived, s.ICMPSent)
+	}
+	return line
+}
+
+// Threshold is the outcome of a pass/fail threshold of a flow.
+type Threshold struct {
+	Name  string `json:"name"`
+	Limit string `json:"limit"`
+	Value string `json:"value"`
+	OK    bool   `json:"ok"`
+}
+
+// Phase is the result of a flow during one phase of the test.
+type Phase struct {
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Sent            uint64  `json:"sent"`
+	Received        uint64  `json:"received"`
+	// OpsPerSecond is the rate of messages received.
+	OpsPerSecond  float64 `json:"ops_per_second"`
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+	// Stalls is the number of gaps longer than the stall threshold, and
+	// StalledSeconds their total duration.
+	Stalls         int     `json:"stalls"`
+	StalledSeconds float64 `json:"stalled_seconds"`
+	// Round-trip times, in echo mode only.
+	RTTMinSeconds float64 `json:"rtt_min_seconds,omitempty"`
+	RTTAvgSeconds float64 `json:"rtt_avg_seconds,omitempty"`
+	RTTMaxSeconds float64 `json:"rtt_max_seconds,omitempty"`
+}
+
+func (p Phase) String() string {
+	line := fmt.Sprintf("phase %s: %s, %.1f ops/s, longest gap %s, %d stalls (%s)",
+		p.Name, Seconds(p.DurationSeconds), p.OpsPerSecond, Seconds(p.MaxGapSeconds), p.Stalls, Seconds(p.StalledSeconds))
+	if p.RTTMaxSeconds > 0 {
+		line += fmt.Sprintf(", rtt min/avg/max %s/%s/%s",
+			microseconds(p.RTTMinSeconds), microseconds(p.RTTAvgSeconds), microseconds(p.RTTMaxSeconds))
+	}
+	return line
+}
+
+// Direction summarizes the messages sent in one direction of a flow, as far as
+// the client can tell: how many of its own messages arrived, and how far
+// apart, is only known in echo mode and is zero otherwise.
+type Direction struct {
+	// Direction is one of "client->server" and "server->client".
+	Direction     string  `json:"direction"`
+	Sent          uint64  `json:"sent"`
+	Received      uint64  `json:"received"`
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+}
+
+// ID returns the flow's name, qualified with the client if set.
+func (f Flow) ID() string {
+	if f.Client == "" {
+		return f.Name
+	}
+	return f.Client + "/" + f.Name
+}
+
+func (f Flow) String() string {
+	line := fmt.Sprintf("%s: sent %d messages and received %d in %s, longest gap %s",
+		f.ID(), f.Sent, f.Received, Seconds(f.DurationSeconds), Seconds(f.MaxGapSeconds))
+	if f.MissedSlots > 0 {
+		line += fmt.Sprintf(", missed %d send slots (%d dropped)", f.MissedSlots, f.DroppedSlots)
+	}
+	if f.UpstreamDelaySeconds > 0 || f.DownstreamDelaySeconds > 0 {
+		line += fmt.Sprintf(", average delay %s up and %s down", Seconds(f.UpstreamDelaySeconds), Seconds(f.DownstreamDelaySeconds))
+	}
+	if f.ClientBoundSeconds > 0 {
+		line += fmt.Sprintf(", client-bound for %ds at %.1f of %.1f messages/s", f.ClientBoundSeconds, f.SendRate, f.TargetRate)
+	}
+	if !f.OK {
+		line += ", failed: " + f.Error
+	}
+	for _, p := range f.Phases {
+		line += "\n  " + p.String()
+	}
+	return line
+}
+
+// Flows are the results of all targets of a client.
+type Flows []Flow
+
+func (fs Flows) String() string {
+	lines := make([]string, len(fs))
+	for i, f := range fs {
+		lines[i] = f.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Seconds converts seconds as found in results to a duration rounded to
+// milliseconds, for display.
+func Seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+// microseconds is like [Seconds], for short durations such as round-trip
+// times.
+func microseconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
@@ -0,0 +1,92 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() Report {
+	return Aggregate(map[string]Flows{
+		"client-1": {
+			{Name: "a", OK: true, Sent: 100, Received: 100, DurationSeconds: 10},
+			{
+				Name: "b|c", Error: "threshold max_gap violated", Failure: "threshold", Threshold: "max_gap",
+				Sent: 100, Received: 90, MaxGapSeconds: 1.5, DurationSeconds: 10,
+				Stalls: 1, StalledSeconds: 1.5,
+				StallList:  []Stall{{Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), DurationSeconds: 1.5}},
+				Thresholds: []Threshold{{Name: "max_gap", Limit: "500ms", Value: "1.5s"}, {Name: "max_dial_retries", Limit: "0", Value: "0", OK: true}},
+			},
+		},
+		"client-2": {{Name: "a", OK: true, Sent: 100, Received: 100, DurationSeconds: 10}},
+	}, 2)
+}
+
+func TestJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteJUnit(&buf, "tcd"); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, buf.String())
+	}
+	// The violated threshold fails its own test case rather than the flow's.
+	if len(suites.Suites) != 2 || suites.Tests != 5 || suites.Failures != 1 {
+		t.Fatalf("unexpected suites: %+v", suites)
+	}
+
+	cases := suites.Suites[0].Cases
+	if cases[1].Failure != nil || !strings.Contains(cases[1].SystemOut, "2024-01-01T00:00:00Z for 1.5s") {
+		t.Errorf("unexpected failed flow: %+v", cases[1])
+	}
+	if cases[2].Name != "b|c threshold max_gap" || cases[2].Failure == nil || cases[2].Failure.Type != "threshold" || cases[3].Failure != nil {
+		t.Errorf("unexpected threshold cases: %+v", cases[2:])
+	}
+}
+
+func TestJUnitFailedFlow(t *testing.T) {
+	// The flow failed for another reason than the threshold it also violated,
+	// so both fail.
+	rep := Aggregate(map[string]Flows{"": {{
+		Name: "a", Error: "timeout", Failure: "timeout",
+		Thresholds: []Threshold{{Name: "max_gap", Limit: "500ms", Value: "5s"}},
+	}}}, 0)
+
+	var buf bytes.Buffer
+	if err := rep.WriteJUnit(&buf, "tcd"); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %s\n%s", err, buf.String())
+	}
+	if suites.Tests != 2 || suites.Failures != 2 {
+		t.Fatalf("unexpected suites: %+v", suites)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	md := buf.String()
+
+	for _, want := range []string{
+		"**FAIL: 1 of 3 flows failed across 2 clients (2 expected)",
+		`| client-1/b\|c | **fail** (threshold) | 100 | 90 | 1.5s | 1 (1.5s) |`,
+		`| client-1/b\|c | max_gap | 500ms | 1.5s |`,
+		"- 2024-01-01T00:00:00Z for 1.5s",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("missing %q in:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "max_dial_retries") {
+		t.Errorf("passing threshold listed in:\n%s", md)
+	}
+}
@@ -0,0 +1,218 @@
+// Package scenario loads declarative client scenarios from YAML or JSON
+// files. A scenario lists the targets a client exercises in one process, each
+// with its own transport, traffic pattern, socket options and pass/fail
+// thresholds:
+//
+//	defaults:
+//	  interval: 10ms
+//	  timeout: 2s
+//	targets:
+//	  - name: pod-to-pod
+//	    addr: 10.0.1.23:8000
+//	  - name: pod-to-service
+//	    addr: echo.default.svc:8000
+//	    mode: duplex
+//	    socket:
+//	      mark: 0x200
+//	    thresholds:
+//	      max_gap: 500ms
+//	      max_loss: 0
+//	      min_ops_per_second: 80
+//
+// Fields missing from a target are taken from the defaults, which in turn
+// default to the client's command line flags.
+package scenario
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+)
+
+// Scenario is a set of targets to run concurrently.
+type Scenario struct {
+	Targets []Target
+}
+
+// Target is a single flow of a scenario.
+type Target struct {
+	// Name labels the target's results. Defaults to Addr.
+	Name string `yaml:"name"`
+	Addr string `yaml:"addr"`
+
+	Transport     string        `yaml:"transport"`
+	Mode          string        `yaml:"mode"`
+	Interval      time.Duration `yaml:"interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Rate          float64       `yaml:"rate"`
+	Arrival       string        `yaml:"arrival"`
+	HalfClose     bool          `yaml:"half_close"`
+	ProxyProtocol int           `yaml:"proxy_protocol"`
+	OneWayDelay   bool          `yaml:"one_way_delay"`
+	ICMPInterval  time.Duration `yaml:"icmp_interval"`
+	DialAttempts  int           `yaml:"dial_attempts"`
+	// ConnectTimeout bounds every connection attempt, see
+	// [client.Config.ConnectTimeout].
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+
+	Socket     internal.SocketOptions `yaml:"socket"`
+	Thresholds client.Thresholds      `yaml:"thresholds"`
+}
+
+// ClientConfig returns the configuration of a client for the target.
+func (t Target) ClientConfig() client.Config {
+	return client.Config{
+		Addr:           t.Addr,
+		Transport:      t.Transport,
+		Mode:           t.Mode,
+		Interval:       t.Interval,
+		Timeout:        t.Timeout,
+		Rate:           t.Rate,
+		Arrival:        t.Arrival,
+		HalfClose:      t.HalfClose,
+		ProxyProtocol:  t.ProxyProtocol,
+		OneWayDelay:    t.OneWayDelay,
+		ICMPInterval:   t.ICMPInterval,
+		DialAttempts:   t.DialAttempts,
+		ConnectTimeout: t.ConnectTimeout,
+		Socket:         t.Socket,
+		Thresholds:     t.Thresholds,
+	}
+}
+
+// file is the on-disk representation of a scenario. Targets are decoded on
+// top of the defaults, so that they only need to list what differs.
+type file struct {
+	Defaults yaml.Node   `yaml:"defaults"`
+	Targets  []yaml.Node `yaml:"targets"`
+}
+
+// Load reads a scenario from a YAML or JSON file. base holds the defaults for
+// fields set neither by the target nor the file's defaults.
+func Load(path string, base Target) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data, base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parse parses a scenario, see [Load].
+func Parse(data []byte, base Target) (*Scenario, error) {
+	var f file
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+
+	defaults := base
+	if err := decode(&f.Defaults, &defaults); err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+	if defaults.Name != "" || defaults.Addr != "" {
+		return nil, errors.New("defaults: name and addr must be set per target")
+	}
+
+	s := &Scenario{}
+	for i, node := range f.Targets {
+		t := defaults
+		if err := decode(&node, &t); err != nil {
+			return nil, fmt.Errorf("target %d: %w", i, err)
+		}
+		s.Targets = append(s.Targets, t)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// decode decodes node into out, leaving fields it doesn't set untouched.
+func decode(node *yaml.Node, out *Target) error {
+	if node.IsZero() {
+		return nil
+	}
+	// Decoding writes through pointers, don't let it modify those shared with
+	// the defaults.
+	out.Thresholds.MaxLoss = clone(out.Thresholds.MaxLoss)
+	out.Thresholds.MaxDialRetries = clone(out.Thresholds.MaxDialRetries)
+
+	// Node.Decode doesn't support rejecting unknown fields, so round-trip
+	// through a decoder that does.
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(out)
+}
+
+// clone returns a pointer to a copy of *p, or nil if p is nil.
+func clone[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// Validate checks the scenario for errors and names unnamed targets after
+// their address.
+func (s *Scenario) Validate() error {
+	if len(s.Targets) == 0 {
+		return errors.New("no targets")
+	}
+
+	names := make(map[string]bool)
+	for i := range s.Targets {
+		t := &s.Targets[i]
+		if t.Addr == "" {
+			return fmt.Errorf("target %d: missing addr", i)
+		}
+		if t.Name == "" {
+			t.Name = t.Addr
+		}
+		if names[t.Name] {
+			return fmt.Errorf("target %d: duplicate name %q", i, t.Name)
+		}
+		names[t.Name] = true
+
+		if !slices.Contains(internal.Transports, t.Transport) {
+			return fmt.Errorf("target %s: unsupported transport %q", t.Name, t.Transport)
+		}
+		if !slices.Contains(internal.Modes, t.Mode) {
+			return fmt.Errorf("target %s: unsupported mode %q", t.Name, t.Mode)
+		}
+		if t.Interval <= 0 || t.Timeout <= 0 {
+			return fmt.Errorf("target %s: interval and timeout must be positive", t.Name)
+		}
+		if t.Rate < 0 {
+			return fmt.Errorf("target %s: rate must not be negative", t.Name)
+		}
+		if err := t.Socket.Validate(t.Transport); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if t.OneWayDelay && t.Mode != internal.ModeEcho {
+			return fmt.Errorf("target %s: one-way delays are only measured in %s mode", t.Name, internal.ModeEcho)
+		}
+		if t.Arrival != "" && !slices.Contains(internal.Arrivals, t.Arrival) {
+			return fmt.Errorf("target %s: unsupported arrival process %q", t.Name, t.Arrival)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package scenario
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+var base = Target{
+	Transport: internal.TransportTCP,
+	Mode:      internal.ModeEcho,
+	Interval:  50 * time.Millisecond,
+	Timeout:   5 * time.Second,
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`
+defaults:
+  interval: 10ms
+  half_close: true
+targets:
+  - addr: a:8000
+  - name: b
+    addr: b:8000
+    mode: duplex
+    half_close: false
+    socket:
+      mark: 0x200
+    thresholds:
+      max_gap: 500ms
+`), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(s.Targets))
+	}
+	a, b := s.Targets[0], s.Targets[1]
+
+	if a.Name != "a:8000" || a.Interval != 10*time.Millisecond || !a.HalfClose || a.Timeout != base.Timeout || a.Mode != internal.ModeEcho {
+		t.Errorf("unexpected defaults applied to target a: %+v", a)
+	}
+	if b.Name != "b" || b.Mode != internal.ModeDuplex || b.HalfClose || b.Socket.Mark != 0x200 || b.Thresholds.MaxGap != 500*time.Millisecond {
+		t.Errorf("unexpected target b: %+v", b)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	s, err := Parse([]byte(`{"targets": [{"name": "a", "addr": "a:8000", "timeout": "1s"}]}`), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Targets[0].Timeout; got != time.Second {
+		t.Fatalf("expected timeout 1s, got %s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"unknown field", "targets: [{addr: a:1, bogus: 1}]", "field bogus not found"},
+		{"unknown default", "defaults: {bogus: 1}\ntargets: [{addr: a:1}]", "field bogus not found"},
+		{"no targets", "targets: []", "no targets"},
+		{"missing addr", "targets: [{name: a}]", "missing addr"},
+		{"duplicate name", "targets: [{addr: a:1}, {addr: a:1}]", "duplicate name"},
+		{"default addr", "defaults: {addr: a:1}\ntargets: [{name: a}]", "must be set per target"},
+		{"mode", "targets: [{addr: a:1, mode: bogus}]", "unsupported mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), base)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	loss := 0.5
+	base := base
+	base.Thresholds.MaxLoss = &loss
+
+	s, err := Parse([]byte(`
+targets:
+  - addr: a:8000
+    thresholds:
+      max_loss: 0
+      max_dial_retries: 2
+  - addr: b:8000
+`), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := s.Targets[0].Thresholds, s.Targets[1].Thresholds
+	if *a.MaxLoss != 0 || a.MaxDialRetries == nil || *a.MaxDialRetries != 2 {
+		t.Errorf("unexpected thresholds of target a: %+v", a)
+	}
+	// Overriding the default for one target must not affect the others.
+	if *b.MaxLoss != loss || b.MaxDialRetries != nil || loss != 0.5 {
+		t.Errorf("unexpected thresholds of target b: %+v", b)
+	}
+}
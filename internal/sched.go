@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Scheduling policies of the threads pacing messages, see [Scheduling].
+const (
+	SchedOther = "other"
+	SchedBatch = "batch"
+	SchedIdle  = "idle"
+	SchedFIFO  = "fifo"
+	SchedRR    = "rr"
+)
+
+// SchedPolicies are the supported scheduling policies.
+var SchedPolicies = []string{SchedOther, SchedBatch, SchedIdle, SchedFIFO, SchedRR}
+
+// schedPolicyNumbers maps scheduling policies to their numbers in the kernel.
+var schedPolicyNumbers = map[string]int{
+	SchedOther: 0,
+	SchedFIFO:  1,
+	SchedRR:    2,
+	SchedBatch: 3,
+	SchedIdle:  5,
+}
+
+// maxCPUs is the number of CPUs an affinity mask covers, as the C library's
+// cpu_set_t.
+const maxCPUs = 1024
+
+type cpuSet [maxCPUs / 64]uint64
+
+// Scheduling configures how the OS schedules the process and the threads
+// pacing messages, so that scheduling noise of the client or server isn't
+// mistaken for a disruption of the network.
+type Scheduling struct {
+	// Nice is the niceness of the process, from -20 to 19. Lowering it
+	// requires CAP_SYS_NICE.
+	Nice int
+
+	// Policy is one of [SchedPolicies] for the threads pacing messages, with
+	// Priority from 1 to 99 for the realtime fifo and rr policies, which
+	// require CAP_SYS_NICE. Empty keeps the process' policy.
+	Policy   string
+	Priority int
+	// CPUs pins the threads pacing messages to these CPUs. Empty keeps the
+	// process' affinity.
+	CPUs []int
+	// TimerSlack is how late the kernel may wake up the threads pacing
+	// messages to coalesce wakeups. Zero keeps the default of 50µs.
+	TimerSlack time.Duration
+}
+
+// Validate checks the settings are in range.
+func (s Scheduling) Validate() error {
+	if s.Nice < -20 || s.Nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19, got %d", s.Nice)
+	}
+	if s.Policy != "" && !slices.Contains(SchedPolicies, s.Policy) {
+		return fmt.Errorf("unsupported scheduling policy %q", s.Policy)
+	}
+	realtime := s.Policy == SchedFIFO || s.Policy == SchedRR
+	if realtime && (s.Priority < 1 || s.Priority > 99) {
+		return fmt.Errorf("priority of the %s policy must be between 1 and 99, got %d", s.Policy, s.Priority)
+	}
+	if !realtime && s.Priority != 0 {
+		return fmt.Errorf("priority requires the %s or %s policy", SchedFIFO, SchedRR)
+	}
+	for _, cpu := range s.CPUs {
+		if cpu < 0 || cpu >= maxCPUs {
+			return fmt.Errorf("CPU %d out of range", cpu)
+		}
+	}
+	if s.TimerSlack < 0 {
+		return fmt.Errorf("timer slack must not be negative, got %s", s.TimerSlack)
+	}
+	return nil
+}
+
+// ApplyProcess sets the niceness of the process. Linux keeps it per thread,
+// so it is set on every thread, and threads created afterwards inherit it.
+func (s Scheduling) ApplyProcess() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		err = syscall.Setpriority(syscall.PRIO_PROCESS, tid, s.Nice)
+		if errors.Is(err, syscall.ESRCH) {
+			// The thread exited in the meantime.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("set priority: %w", err)
+		}
+	}
+	return nil
+}
+
+// ApplyThread applies the settings for threads pacing messages to the calling
+// thread. The calling goroutine must be locked to its OS thread using
+// [runtime.LockOSThread], and must not unlock it, so that the settings don't
+// leak to other goroutines.
+func (s Scheduling) ApplyThread() error {
+	if s.Policy != "" {
+		param := struct{ priority int32 }{int32(s.Priority)}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(schedPolicyNumbers[s.Policy]), uintptr(unsafe.Pointer(&param))); errno != 0 {
+			return fmt.Errorf("set scheduling policy %s: %w", s.Policy, errno)
+		}
+	}
+	if len(s.CPUs) > 0 {
+		var set cpuSet
+		for _, cpu := range s.CPUs {
+			set[cpu/64] |= 1 << (cpu % 64)
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set))); errno != 0 {
+			return fmt.Errorf("set CPU affinity: %w", errno)
+		}
+	}
+	if s.TimerSlack > 0 {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_TIMERSLACK, uintptr(s.TimerSlack.Nanoseconds()), 0); errno != 0 {
+			return fmt.Errorf("set timer slack: %w", errno)
+		}
+	}
+	return nil
+}
+
+// Check applies the settings for threads pacing messages to a throwaway
+// thread, to find out early whether they can be applied, such as with the
+// privileges a realtime policy requires.
+func (s Scheduling) Check() error {
+	if s.Policy == "" && len(s.CPUs) == 0 && s.TimerSlack == 0 {
+		return nil
+	}
+	errc := make(chan error)
+	go func() {
+		// The thread exits along with the goroutine, dropping its settings.
+		runtime.LockOSThread()
+		errc <- s.ApplyThread()
+	}()
+	return <-errc
+}
+
+// SchedAttrs returns the effective scheduling settings of the calling thread
+// as log attributes, leaving out those that can't be read.
+func SchedAttrs() []any {
+	var attrs []any
+	// The raw syscall returns 20 minus the niceness, to stay positive.
+	if prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid()); err == nil {
+		attrs = append(attrs, "nice", 20-prio)
+	}
+	if policy, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETSCHEDULER, 0, 0, 0); errno == 0 {
+		name := strconv.Itoa(int(policy))
+		for n, number := range schedPolicyNumbers {
+			if number == int(policy) {
+				name = n
+			}
+		}
+		attrs = append(attrs, "policy", name)
+		var param struct{ priority int32 }
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETPARAM, 0, uintptr(unsafe.Pointer(&param)), 0); errno == 0 && param.priority > 0 {
+			attrs = append(attrs, "priority", param.priority)
+		}
+	}
+	var set cpuSet
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set))); errno == 0 {
+		attrs = append(attrs, "cpus", set.String())
+	}
+	if slack, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_GET_TIMERSLACK, 0, 0); errno == 0 {
+		attrs = append(attrs, "timer_slack", time.Duration(slack))
+	}
+	return attrs
+}
+
+// String returns the CPUs in the set as a list of ranges, e.g. "0-3,6".
+func (s *cpuSet) String() string {
+	var ranges []string
+	for cpu := 0; cpu < maxCPUs; cpu++ {
+		if !s.has(cpu) {
+			continue
+		}
+		last := cpu
+		for last+1 < maxCPUs && s.has(last+1) {
+			last++
+		}
+		if last == cpu {
+			ranges = append(ranges, strconv.Itoa(cpu))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", cpu, last))
+		}
+		cpu = last
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (s *cpuSet) has(cpu int) bool {
+	return s[cpu/64]&(1<<(cpu%64)) != 0
+}
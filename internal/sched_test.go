@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSchedulingValidate(t *testing.T) {
+	for _, s := range []Scheduling{
+		{Nice: 20},
+		{Policy: "deadline"},
+		{Policy: SchedFIFO},
+		{Policy: SchedRR, Priority: 100},
+		{Policy: SchedOther, Priority: 10},
+		{CPUs: []int{-1}},
+		{TimerSlack: -time.Microsecond},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected error for %+v", s)
+		}
+	}
+	if err := (Scheduling{Nice: -5, Policy: SchedFIFO, Priority: 50, CPUs: []int{0, 3}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulingApplyThread(t *testing.T) {
+	done := make(chan map[string]any)
+	go func() {
+		// The thread exits along with the goroutine, dropping its settings.
+		runtime.LockOSThread()
+
+		// Pin to a CPU the test may run on.
+		var set cpuSet
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set))); errno != 0 {
+			t.Error(errno)
+			close(done)
+			return
+		}
+		cpu := 0
+		for !set.has(cpu) {
+			cpu++
+		}
+
+		s := Scheduling{Policy: SchedBatch, CPUs: []int{cpu}, TimerSlack: time.Millisecond}
+		if err := s.ApplyThread(); err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		attrs := SchedAttrs()
+		effective := map[string]any{"want_cpus": strconv.Itoa(cpu)}
+		for i := 0; i < len(attrs); i += 2 {
+			effective[attrs[i].(string)] = attrs[i+1]
+		}
+		done <- effective
+	}()
+
+	effective, ok := <-done
+	if !ok {
+		return
+	}
+	if effective["policy"] != SchedBatch || effective["cpus"] != effective["want_cpus"] || effective["timer_slack"] != time.Millisecond {
+		t.Fatalf("unexpected effective settings %v", effective)
+	}
+}
+
+func TestCPUSetString(t *testing.T) {
+	var set cpuSet
+	for _, cpu := range []int{0, 1, 2, 3, 6, 64, 65} {
+		set[cpu/64] |= 1 << (cpu % 64)
+	}
+	if got, want := set.String(), "0-3,6,64-65"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
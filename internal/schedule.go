@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Arrival processes of a [Schedule].
+const (
+	// ArrivalConstant sends at a fixed interval.
+	ArrivalConstant = "constant"
+	// ArrivalPoisson sends with exponentially distributed intervals, as
+	// independent requests of many users arrive.
+	ArrivalPoisson = "poisson"
+)
+
+// Arrivals are the supported arrival processes.
+var Arrivals = []string{ArrivalConstant, ArrivalPoisson}
+
+// maxBacklog bounds how far a [Schedule] falls behind its timeline. Slots
+// further behind are dropped rather than sent in one burst.
+const maxBacklog = time.Second
+
+// Schedule spaces out sends on an absolute timeline at a target rate, as an
+// open loop. Unlike [Pacer], it doesn't lose time when a send is late: the
+// following sends go out back to back until it caught up. Every send keeps
+// the time it was intended for, so that latencies measured from it include
+// the sender's own delays instead of omitting them.
+type Schedule struct {
+	mean    time.Duration
+	poisson bool
+	rng     *rand.Rand
+	next    time.Time
+}
+
+// Slot is a send slot of a [Schedule].
+type Slot struct {
+	// Intended is when the send was due.
+	Intended time.Time
+	// Missed is the number of slots up to this one the schedule didn't meet,
+	// as they are sent a full interval late or dropped.
+	Missed int
+	// Dropped is the number of slots skipped before this one as the schedule
+	// fell too far behind.
+	Dropped int
+}
+
+// NewSchedule returns a schedule of the given rate per second and one of
+// [Arrivals].
+func NewSchedule(rate float64, arrival string) (*Schedule, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive, got %g", rate)
+	}
+	if !slices.Contains(Arrivals, arrival) {
+		return nil, fmt.Errorf("unsupported arrival process %q", arrival)
+	}
+	return &Schedule{
+		mean:    max(time.Duration(float64(time.Second)/rate), 1),
+		poisson: arrival == ArrivalPoisson,
+		rng:     rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}, nil
+}
+
+// Interval returns the mean interval between two sends.
+func (s *Schedule) Interval() time.Duration {
+	return s.mean
+}
+
+// Wait sleeps until the next send is due and returns its slot. The timeline
+// starts with the first call.
+//
+// Like [Pacer.Wait], the calling goroutine should be locked to its OS thread.
+func (s *Schedule) Wait() Slot {
+	now := time.Now()
+	if s.next.IsZero() {
+		s.next = now
+	}
+
+	var slot Slot
+	for now.Sub(s.next) > maxBacklog {
+		s.next = s.next.Add(s.gap())
+		slot.Dropped++
+	}
+	Sleep(time.Until(s.next))
+
+	slot.Intended, slot.Missed = s.next, slot.Dropped
+	if time.Since(s.next) > s.mean {
+		slot.Missed++
+	}
+	s.next = s.next.Add(s.gap())
+	return slot
+}
+
+// gap returns the time from one slot to the next.
+func (s *Schedule) gap() time.Duration {
+	if !s.poisson {
+		return s.mean
+	}
+	return time.Duration(s.rng.ExpFloat64() * float64(s.mean))
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	if _, err := NewSchedule(0, ArrivalConstant); err == nil {
+		t.Fatal("expected error for zero rate")
+	}
+	if _, err := NewSchedule(10, "bursty"); err == nil {
+		t.Fatal("expected error for invalid arrival process")
+	}
+
+	s, err := NewSchedule(1000, ArrivalConstant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := s.Wait()
+	if first.Missed != 0 || first.Dropped != 0 {
+		t.Fatalf("unexpected first slot %+v", first)
+	}
+	second := s.Wait()
+	if got := second.Intended.Sub(first.Intended); got != time.Millisecond {
+		t.Fatalf("expected slots 1ms apart, got %s", got)
+	}
+
+	// Falling behind by more than the backlog drops the slots beyond it and
+	// misses the rest.
+	s.next = s.next.Add(-2 * maxBacklog)
+	late := s.Wait()
+	if late.Dropped < 900 || late.Dropped > 1100 || late.Missed != late.Dropped+1 {
+		t.Fatalf("unexpected slot after stall %+v", late)
+	}
+	if behind := time.Since(late.Intended); behind < maxBacklog-10*time.Millisecond || behind > maxBacklog+100*time.Millisecond {
+		t.Fatalf("expected to resume %s behind, resumed %s behind", maxBacklog, behind)
+	}
+}
+
+func TestSchedulePoisson(t *testing.T) {
+	s, err := NewSchedule(100, ArrivalPoisson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10000
+	var sum time.Duration
+	for range n {
+		sum += s.gap()
+	}
+	if mean := sum / n; mean < 9*time.Millisecond || mean > 11*time.Millisecond {
+		t.Fatalf("expected mean interval of about 10ms, got %s", mean)
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+// connStats holds the counters of a single connection driven by the server.
+type connStats struct {
+	rx, tx atomic.Uint64
+	bytes  atomic.Uint64
+
+	// Total number of messages sent and received, unlike tx and rx which are
+	// reset every second.
+	sent, received atomic.Uint64
+
+	// maxGap is the longest gap between two messages received, set by the
+	// reader.
+	maxGap time.Duration
+}
+
+// drive sends sequence-numbered messages to the client at the configured
+// interval. In reverse mode, it verifies they're echoed back in order, making
+// the server the active side of the connection that detects stalls. In duplex
+// mode, it validates the client's independent stream instead.
+//
+// The connection is torn down when ctx is cancelled, unless half-closing is
+// enabled. In that case, the server stops sending, shuts down the writing side
+// of the connection and waits for the client to finish its side.
+//
+// Returns nil when the client closes the connection or the server is shutting
+// down.
+func (s *Server) drive(ctx context.Context, conn net.Conn, log *slog.Logger) error {
+	payload, err := internal.NewPayload()
+	if err != nil {
+		return err
+	}
+
+	var stats connStats
+
+	// Tear down the connection on errors and when both sides are done, which
+	// also unblocks the reader when the writer fails and vice versa.
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	stop := context.AfterFunc(abortCtx, func() { conn.Close() })
+	defer stop()
+
+	// The writer stops when the server is shutting down, when the connection is
+	// torn down, or when the client ended its stream in duplex mode.
+	writerCtx, stopWriter := context.WithCancel(ctx)
+	defer stopWriter()
+	context.AfterFunc(abortCtx, stopWriter)
+
+	if !s.cfg.HalfClose {
+		context.AfterFunc(ctx, abort)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-abortCtx.Done():
+				return
+			case <-ticker.C:
+				log.Info("Operations per second", "tx", stats.tx.Swap(0), "rx", stats.rx.Swap(0), "bytes", stats.bytes.Swap(0))
+			}
+		}
+	}()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		err := s.driveWriter(writerCtx, conn, &payload, &stats, log)
+		if err != nil {
+			// Stop the reader when the writer fails, or the ErrGroup will wait
+			// forever.
+			abort()
+		}
+		return err
+	})
+	eg.Go(func() error {
+		if s.cfg.Mode == internal.ModeDuplex {
+			r := internal.StreamReader{
+				Conn:           conn,
+				Direction:      internal.DirectionClientToServer,
+				Timeout:        s.cfg.Timeout,
+				StallThreshold: s.cfg.StallThreshold,
+				Log:            log,
+				Received: func() {
+					stats.rx.Add(1)
+					stats.received.Add(1)
+					stats.bytes.Add(internal.MsgSize)
+				},
+			}
+			err := r.Run(abortCtx)
+			stats.maxGap = r.MaxGap
+			if err == nil && s.cfg.HalfClose {
+				// The client ended its stream, so end ours as well and let the writer
+				// half-close the connection.
+				stopWriter()
+				return nil
+			}
+			abort()
+			return err
+		}
+
+		defer abort()
+		return s.driveReader(abortCtx, conn, &payload, &stats, log)
+	})
+
+	err = eg.Wait()
+	log.Info("Connection summary", "sent", stats.sent.Load(), "received", stats.received.Load(), "max_gap", stats.maxGap.Round(time.Millisecond))
+	return err
+}
+
+func (s *Server) driveWriter(ctx context.Context, conn net.Conn, payload *internal.Payload, stats *connStats, log *slog.Logger) error {
+	pacer := internal.NewPacer(s.cfg.Interval)
+
+	// Lock the goroutine to its OS thread for more accurate pacing, see
+	// [internal.Pacer]. Every connection gets its own writer, so this pins one
+	// OS thread per connection.
+	runtime.LockOSThread()
+	if err := s.cfg.Scheduling.ApplyThread(); err != nil {
+		return err
+	}
+
+	log.Info("Sending requests", "interval", s.cfg.Interval, "timeout", s.cfg.Timeout, slog.Group("sched", internal.SchedAttrs()...))
+
+	request := make([]byte, internal.MsgSize)
+	for seq := uint64(0); ; seq++ {
+		if ctx.Err() != nil {
+			if s.cfg.HalfClose {
+				log.Info("Half-closing the connection", internal.LogSeq, seq)
+				if err := internal.CloseWrite(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+					return fmt.Errorf("close write: %w", err)
+				}
+			}
+			return nil
+		}
+
+		pacer.Start()
+
+		if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			return fmt.Errorf("set write deadline: %w", err)
+		}
+
+		payload.Encode(request, seq)
+		_, err := conn.Write(request)
+		if errors.Is(err, net.ErrClosed) && ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("conn write: %w", err)
+		}
+
+		stats.tx.Add(1)
+		stats.sent.Add(1)
+
+		pacer.Wait()
+	}
+}
+
+// driveReader verifies the client's echoes in reverse mode, reporting gaps
+// once the echoes resume like [internal.StreamReader]. Returns nil when the
+// connection is closed after abortCtx is cancelled.
+func (s *Server) driveReader(abortCtx context.Context, conn net.Conn, payload *internal.Payload, stats *connStats, log *slog.Logger) error {
+	last := time.Now()
+	reply := make([]byte, internal.MsgSize)
+	for seq := uint64(0); ; {
+		if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			if errors.Is(err, net.ErrClosed) && abortCtx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("set read deadline: %w", err)
+		}
+
+		_, err := io.ReadFull(conn, reply)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if time.Since(last) <= s.cfg.Timeout {
+				continue
+			}
+			stats.maxGap = max(stats.maxGap, time.Since(last))
+			return fmt.Errorf("no reply received within %v timeout: %w", s.cfg.Timeout, err)
+		}
+		if errors.Is(err, net.ErrClosed) && abortCtx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			if !s.cfg.HalfClose {
+				log.Info("Client closed the connection")
+				return nil
+			}
+			// After half-closing, the client echoes what's still in flight and then
+			// closes its side. Every message must have made it through by then.
+			if sent := stats.sent.Load(); seq != sent {
+				return fmt.Errorf("client closed the connection after %d of %d replies: %w", seq, sent, err)
+			}
+			log.Info("Client closed the connection after echoing all messages", internal.LogSeq, seq)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read reply: %w", err)
+		}
+
+		if err := payload.Verify(reply, seq); err != nil {
+			return fmt.Errorf("invalid reply: %w", err)
+		}
+
+		gap := time.Since(last)
+		stats.maxGap = max(stats.maxGap, gap)
+		if gap > s.cfg.StallThreshold {
+			log.Warn("Replies resumed after a gap", "gap", gap.Round(time.Millisecond), internal.LogSeq, seq)
+		}
+
+		seq++
+		last = time.Now()
+		stats.rx.Add(1)
+		stats.received.Add(1)
+		stats.bytes.Add(internal.MsgSize)
+	}
+}
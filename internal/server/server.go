@@ -0,0 +1,310 @@
+// Package server implements the connection disruption test server. By
+// default it echoes every message it receives. In reverse and duplex mode, it
+// drives the flow to its clients itself.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/events"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Transport is one of [internal.Transports].
+	Transport string
+	// Mode is one of [internal.Modes] and must match the clients' mode.
+	Mode string
+	// Interval is the target interval between two messages in reverse and
+	// duplex mode.
+	Interval time.Duration
+	// Timeout is the longest time the server waits for a message in reverse and
+	// duplex mode, and for the PROXY protocol header.
+	Timeout time.Duration
+	// StallThreshold is the shortest gap between two messages received in
+	// reverse and duplex mode that gets reported once they resume. Defaults to
+	// one second.
+	StallThreshold time.Duration
+
+	// HalfClose makes the server half-close connections on shutdown in reverse
+	// and duplex mode, and verify all messages arrived before closing them.
+	HalfClose bool
+	// ProxyProtocol makes the server expect a PROXY protocol header on every
+	// connection.
+	ProxyProtocol bool
+	// Stamp makes the server append when it received a message and when it
+	// sent it back to every reply in echo mode, see [internal.Stamp], for
+	// clients to measure the delay in each direction. Clients must expect
+	// stamped replies as well.
+	Stamp bool
+	// Scheduling configures the threads sending messages in reverse and duplex
+	// mode, see [internal.Scheduling.ApplyThread]. The zero value keeps the
+	// process' settings.
+	Scheduling internal.Scheduling
+
+	// Log receives progress messages and errors of individual connections.
+	// Defaults to [internal.DefaultLogger].
+	Log *slog.Logger
+	// Events receives the connections accepted and closed for the event log,
+	// see package [events]. Optional.
+	Events *slog.Logger
+}
+
+// Result summarizes a server run.
+type Result struct {
+	// Number of connections accepted, and the number of those that ended with
+	// an error.
+	Connections, Failed uint64
+}
+
+// Conn describes a connection open on a server.
+type Conn struct {
+	// Local and Remote are the addresses of the connection. With the PROXY
+	// protocol, Remote is the original client address conveyed in the header.
+	Local, Remote net.Addr
+	// Proxy is the address the connection was accepted from with the PROXY
+	// protocol, and nil otherwise.
+	Proxy net.Addr
+	// Accepted is when the connection was accepted.
+	Accepted time.Time
+}
+
+// Server is a connection disruption test server.
+type Server struct {
+	cfg      Config
+	log      *slog.Logger
+	listener net.Listener
+
+	connections, failed atomic.Uint64
+
+	// conns holds the open connections, by the connection accepted.
+	connsMu sync.Mutex
+	conns   map[net.Conn]Conn
+}
+
+// Listen returns a server listening on addr.
+func Listen(cfg Config, addr string) (*Server, error) {
+	if !slices.Contains(internal.Modes, cfg.Mode) {
+		return nil, fmt.Errorf("unsupported mode %q", cfg.Mode)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %s", cfg.Interval)
+	}
+	if err := cfg.Scheduling.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Scheduling.Check(); err != nil {
+		return nil, err
+	}
+	if cfg.StallThreshold <= 0 {
+		cfg.StallThreshold = time.Second
+	}
+
+	listener, err := internal.Listen(cfg.Transport, addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	s := &Server{cfg: cfg, log: cfg.Log, listener: listener, conns: make(map[net.Conn]Conn)}
+	if s.log == nil {
+		s.log = internal.DefaultLogger()
+	}
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close closes the listener of a server that isn't serving.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Result returns a summary of the server's run so far.
+func (s *Server) Result() Result {
+	return Result{Connections: s.connections.Load(), Failed: s.failed.Load()}
+}
+
+// Conns returns the connections open on the server, oldest first.
+func (s *Server) Conns() []Conn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns := slices.Collect(maps.Values(s.conns))
+	slices.SortFunc(conns, func(a, b Conn) int { return a.Accepted.Compare(b.Accepted) })
+	return conns
+}
+
+// register adds conn to the open connections until the returned function is
+// called.
+func (s *Server) register(accepted net.Conn, c Conn) func() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	s.conns[accepted] = c
+	return func() {
+		s.connsMu.Lock()
+		defer s.connsMu.Unlock()
+		delete(s.conns, accepted)
+	}
+}
+
+// Serve accepts connections until ctx is cancelled and returns once all of
+// them are closed.
+func (s *Server) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.log.Info("Closing listener")
+		s.listener.Close()
+	}()
+
+	s.log.Info("Listening", "addr", s.listener.Addr(), "transport", s.cfg.Transport, "mode", s.cfg.Mode)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			s.log.Info("Listener closed")
+			return nil
+		}
+		if err != nil {
+			s.listener.Close()
+			return fmt.Errorf("accept conn: %w", err)
+		}
+
+		s.connections.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.read(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) read(ctx context.Context, conn net.Conn) {
+	info := Conn{Local: conn.LocalAddr(), Remote: conn.RemoteAddr(), Accepted: time.Now()}
+	accepted := conn
+	if s.cfg.ProxyProtocol {
+		pconn, err := internal.ReadProxyHeader(conn, s.cfg.Timeout)
+		if err != nil {
+			s.log.Error("Error reading PROXY protocol header", internal.ConnAttrs(conn), "error", err)
+			s.failed.Add(1)
+			conn.Close()
+			return
+		}
+		s.log.Info("Connection proxied", internal.ConnAttrs(pconn), "proxy", pconn.ProxyAddr())
+		conn = pconn
+		info.Remote, info.Proxy = pconn.RemoteAddr(), pconn.ProxyAddr()
+	}
+
+	defer s.register(accepted, info)()
+	s.serve(ctx, conn)
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	// Connections driven by the server shut down gracefully by themselves when
+	// half-closing is enabled, so don't close them when the server shuts down.
+	connCtx := ctx
+	if s.cfg.HalfClose && s.cfg.Mode != internal.ModeEcho {
+		connCtx = context.WithoutCancel(ctx)
+	}
+
+	log := s.log.With(internal.ConnAttrs(conn))
+	connCtx, cancel := context.WithCancel(connCtx)
+	go func() {
+		<-connCtx.Done()
+		log.Info("Closing connection")
+		conn.Close()
+	}()
+
+	// Make sure context done channel unblocks when the reader exits so we don't
+	// leak the goroutine created above.
+	defer cancel()
+
+	log.Info("New connection")
+	defer conn.Close()
+
+	addrs := []any{internal.ConnAttrs(conn)}
+	s.event(events.Accepted, addrs...)
+
+	var err error
+	if s.cfg.Mode != internal.ModeEcho {
+		err = s.drive(ctx, conn, log)
+		if err != nil {
+			log.Error("Error on connection", "error", err)
+			s.failed.Add(1)
+		}
+	} else {
+		err = s.echo(conn, log)
+	}
+
+	switch {
+	case err != nil:
+		s.event(events.Closed, append(addrs, "reason", events.CloseError, "error", err.Error())...)
+	case ctx.Err() != nil:
+		s.event(events.Closed, append(addrs, "reason", events.CloseShutdown)...)
+	default:
+		s.event(events.Closed, append(addrs, "reason", events.ClosePeer)...)
+	}
+}
+
+// echo reads and writes back one message at a time until the connection is
+// closed. Returns an error if it failed.
+func (s *Server) echo(conn net.Conn, log *slog.Logger) error {
+	size := internal.MsgSize
+	if s.cfg.Stamp {
+		size += internal.StampSize
+	}
+	buf := make([]byte, size)
+	for n := 0; ; n++ {
+		_, err := io.ReadFull(conn, buf[:internal.MsgSize])
+		received := time.Now()
+		if errors.Is(err, io.EOF) && s.cfg.HalfClose {
+			// Every message was echoed in full by now, which the client verifies
+			// after receiving our side of the close.
+			log.Info("Client half-closed the connection", internal.LogSeq, n)
+			return nil
+		}
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			log.Error("Error reading", internal.LogSeq, n, "error", err)
+			s.failed.Add(1)
+			return fmt.Errorf("read: %w", err)
+		}
+
+		if s.cfg.Stamp {
+			internal.Stamp(buf, received, time.Now())
+		}
+		_, err = conn.Write(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			log.Error("Error writing", internal.LogSeq, n, "error", err)
+			s.failed.Add(1)
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+}
+
+// event logs an event of the given kind to the event log, if any.
+func (s *Server) event(kind string, args ...any) {
+	if s.cfg.Events != nil {
+		s.cfg.Events.Info(kind, args...)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+)
+
+func TestReverseStall(t *testing.T) {
+	var out bytes.Buffer
+	srv, err := Listen(Config{
+		Transport:      internal.TransportTCP,
+		Mode:           internal.ModeReverse,
+		Interval:       10 * time.Millisecond,
+		Timeout:        2 * time.Second,
+		StallThreshold: 100 * time.Millisecond,
+		Log:            slog.New(slog.NewTextHandler(&out, nil)),
+	}, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx) }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Echo the server's messages like a client, but stop reading for a while
+	// halfway through.
+	echo := func(n int) {
+		t.Helper()
+		msg := make([]byte, internal.MsgSize)
+		for range n {
+			if _, err := io.ReadFull(conn, msg); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	echo(10)
+	time.Sleep(300 * time.Millisecond)
+	// Catch up with the messages sent in the meantime and keep up with the
+	// stream for a while, giving the server time to read the replies.
+	echo(50)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "Replies resumed after a gap") {
+		t.Fatalf("expected the server to report the gap, got:\n%s", out.String())
+	}
+}
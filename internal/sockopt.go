@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// SocketOptions are applied to client sockets before connecting. Zero values
+// leave the system defaults in place.
+type SocketOptions struct {
+	// SendBuffer and ReceiveBuffer set SO_SNDBUF and SO_RCVBUF in bytes.
+	SendBuffer    int `yaml:"send_buffer"`
+	ReceiveBuffer int `yaml:"receive_buffer"`
+	// TOS sets the IPv4 type of service or the IPv6 traffic class.
+	TOS int `yaml:"tos"`
+	// Mark sets SO_MARK, e.g. to steer traffic with policy routing.
+	Mark int `yaml:"mark"`
+	// Nagle enables Nagle's algorithm on TCP connections, which Go disables
+	// by default.
+	Nagle bool `yaml:"nagle"`
+	// KeepAlive is the TCP keep-alive period. Negative disables keep-alives.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+}
+
+// Validate returns an error if options are set that don't apply to the given
+// transport. Nagle's algorithm and keep-alives are specific to TCP, and the
+// SCTP socket would silently ignore them.
+func (o SocketOptions) Validate(transport string) error {
+	if transport == TransportTCP {
+		return nil
+	}
+	if o.Nagle {
+		return fmt.Errorf("socket option nagle is not supported with %s", transport)
+	}
+	if o.KeepAlive != 0 {
+		return fmt.Errorf("socket option keep_alive is not supported with %s", transport)
+	}
+	return nil
+}
+
+// apply sets the options that apply to any socket on fd. ipv6 selects the
+// traffic class over the type of service.
+func (o SocketOptions) apply(fd int, ipv6 bool) error {
+	tos := sockopt{"IP_TOS", syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS}
+	if ipv6 {
+		tos = sockopt{"IPV6_TCLASS", syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS}
+	}
+
+	for _, opt := range []sockopt{
+		{"SO_SNDBUF", syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer},
+		{"SO_RCVBUF", syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReceiveBuffer},
+		{"SO_MARK", syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark},
+		tos,
+	} {
+		if opt.value == 0 {
+			continue
+		}
+		if err := syscall.SetsockoptInt(fd, opt.level, opt.opt, opt.value); err != nil {
+			return fmt.Errorf("set %s: %w", opt.name, err)
+		}
+	}
+	return nil
+}
+
+type sockopt struct {
+	name       string
+	level, opt int
+	value      int
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// Stream directions, used to label reports in duplex mode.
+const (
+	DirectionClientToServer = "client->server"
+	DirectionServerToClient = "server->client"
+)
+
+// StreamReader receives and validates a stream of sequence-numbered messages
+// sent by the peer independently of our own sends, as in duplex mode. The
+// payload of the stream is taken from its first message. Gaps in the stream
+// are reported once it resumes, labelled with the direction.
+type StreamReader struct {
+	Conn net.Conn
+	// Direction of the stream, used to label reports.
+	Direction string
+	// Timeout is the longest time to wait for a message.
+	Timeout time.Duration
+	// StallThreshold is the shortest gap between two messages that gets
+	// reported once the stream resumes. Zero disables the reports, for callers
+	// reporting stalls themselves.
+	StallThreshold time.Duration
+	// Log receives progress messages.
+	Log *slog.Logger
+	// Received is called for every valid message.
+	Received func()
+
+	// MaxGap is the longest gap between two messages, set by Run.
+	MaxGap time.Duration
+}
+
+// Run reads the stream until the peer closes the connection, or until the
+// connection is closed after ctx is cancelled, and returns nil in that case.
+// Returns an error if no message is received within the timeout or a message
+// is invalid.
+func (r *StreamReader) Run(ctx context.Context) error {
+	conn, direction, timeout := r.Conn, r.Direction, r.Timeout
+
+	var payload Payload
+	last := time.Now()
+	msg := make([]byte, MsgSize)
+	for seq := uint64(0); ; {
+		if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			if errors.Is(err, net.ErrClosed) && ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%s: set read deadline: %w", direction, err)
+		}
+
+		_, err := io.ReadFull(conn, msg)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if time.Since(last) <= timeout {
+				continue
+			}
+			r.MaxGap = max(r.MaxGap, time.Since(last))
+			return fmt.Errorf("%s: no message received within %v timeout: %w", direction, timeout, err)
+		}
+		if errors.Is(err, net.ErrClosed) && ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			r.Log.Info("Peer closed the stream", "direction", direction)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: read message: %w", direction, err)
+		}
+
+		if seq == 0 {
+			copy(payload[:], msg[seqSize:])
+		}
+		if err := payload.Verify(msg, seq); err != nil {
+			return fmt.Errorf("%s: invalid message: %w", direction, err)
+		}
+
+		gap := time.Since(last)
+		r.MaxGap = max(r.MaxGap, gap)
+		if r.StallThreshold > 0 && gap > r.StallThreshold {
+			r.Log.Warn("Stream resumed after a gap", "direction", direction, "gap", gap.Round(time.Millisecond), LogSeq, seq)
+		}
+
+		seq++
+		last = time.Now()
+		r.Received()
+	}
+}
+
+// CloseWrite shuts down the writing side of conn, signalling the end of our
+// stream to the peer. Falls back to closing conn entirely if it doesn't
+// support half-closing.
+func CloseWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
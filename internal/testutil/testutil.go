@@ -0,0 +1,21 @@
+// Package testutil holds helpers shared by the tests of several packages.
+package testutil
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// logWriter forwards output to the test log.
+type logWriter struct{ t testing.TB }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Logger returns a logger writing to the test log.
+func Logger(t testing.TB) *slog.Logger {
+	return slog.New(slog.NewTextHandler(logWriter{t}, nil))
+}
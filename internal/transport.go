@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Supported transports.
+const (
+	TransportTCP  = "tcp"
+	TransportSCTP = "sctp"
+)
+
+// Transports lists the transports accepted by [Dial] and [Listen].
+var Transports = []string{TransportTCP, TransportSCTP}
+
+// Dial connects to addr using the given transport and socket options, giving
+// up after timeout unless it is zero, in which case the OS' connect timeout
+// applies. SCTP connections use a one-to-one style socket, so they behave like
+// a TCP stream.
+func Dial(transport, addr string, timeout time.Duration, opts SocketOptions) (net.Conn, error) {
+	if err := opts.Validate(transport); err != nil {
+		return nil, err
+	}
+	switch transport {
+	case TransportTCP:
+		return dialTCP(addr, timeout, opts)
+	case TransportSCTP:
+		return dialSCTP(addr, timeout, opts)
+	}
+	return nil, fmt.Errorf("unsupported transport %q", transport)
+}
+
+// Listen listens on addr using the given transport.
+func Listen(transport, addr string) (net.Listener, error) {
+	switch transport {
+	case TransportTCP:
+		return net.Listen("tcp", addr)
+	case TransportSCTP:
+		return listenSCTP(addr)
+	}
+	return nil, fmt.Errorf("unsupported transport %q", transport)
+}
+
+// sctpSocket creates a non-blocking one-to-one SCTP socket suitable for
+// connecting to or binding to addr.
+func sctpSocket(addr string) (int, syscall.Sockaddr, error) {
+	// The address format is the same as for TCP, so reuse its resolver.
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, nil, fmt.Errorf("resolve %s: %w", addr, err)
+	}
+
+	var sa syscall.Sockaddr
+	family := syscall.AF_INET6
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		family = syscall.AF_INET
+		sa = &syscall.SockaddrInet4{Port: tcpAddr.Port, Addr: [4]byte(ip4)}
+	} else {
+		// An empty host results in a nil IP, which is the IPv6 wildcard address.
+		// The socket accepts IPv4 connections too, as long as the bindv6only
+		// sysctl is disabled.
+		sa6 := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		if tcpAddr.IP != nil {
+			sa6.Addr = [16]byte(tcpAddr.IP.To16())
+		}
+		sa = sa6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, syscall.IPPROTO_SCTP)
+	if err != nil {
+		return -1, nil, fmt.Errorf("open SCTP socket: %w", err)
+	}
+
+	return fd, sa, nil
+}
+
+func dialTCP(addr string, timeout time.Duration, opts SocketOptions) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: opts.KeepAlive,
+		Control: func(network, _ string, rc syscall.RawConn) error {
+			var err error
+			if cerr := rc.Control(func(fd uintptr) {
+				err = opts.apply(int(fd), network == "tcp6")
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Nagle {
+		if err := conn.(*net.TCPConn).SetNoDelay(false); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("enable Nagle's algorithm: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func dialSCTP(addr string, timeout time.Duration, opts SocketOptions) (net.Conn, error) {
+	fd, sa, err := sctpSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ipv6 := sa.(*syscall.SockaddrInet6)
+	if err := opts.apply(fd, ipv6); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	err = syscall.Connect(fd, sa)
+	if err != nil && err != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+
+	// The file takes ownership of the socket from here on.
+	file := os.NewFile(uintptr(fd), "sctp")
+	defer file.Close()
+
+	if err := waitConnected(file, timeout); err != nil {
+		return nil, err
+	}
+
+	// FileConn duplicates the socket and wraps it in a [net.TCPConn], since the
+	// runtime treats any stream socket that way. All we need is read, write and
+	// deadlines, which work regardless of the protocol.
+	return net.FileConn(file)
+}
+
+// waitConnected waits for a non-blocking connect on file to complete, for at
+// most timeout unless it is zero.
+func waitConnected(file *os.File, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := file.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return fmt.Errorf("set connect deadline: %w", err)
+		}
+	}
+
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("get raw conn: %w", err)
+	}
+
+	var connErr error
+	err = rc.Write(func(fd uintptr) bool {
+		// The socket is connected once it has a peer. If it doesn't, look for an
+		// error and otherwise keep waiting for the socket to become writable.
+		if _, err := syscall.Getpeername(int(fd)); err == nil {
+			return true
+		}
+		errno, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err != nil {
+			connErr = err
+			return true
+		}
+		switch syscall.Errno(errno) {
+		case 0, syscall.EINPROGRESS, syscall.EALREADY, syscall.EINTR:
+			return false
+		default:
+			connErr = syscall.Errno(errno)
+			return true
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	if connErr != nil {
+		return fmt.Errorf("connect: %w", connErr)
+	}
+
+	return nil
+}
+
+func listenSCTP(addr string) (net.Listener, error) {
+	fd, sa, err := sctpSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "sctp")
+	defer file.Close()
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return nil, fmt.Errorf("set SO_REUSEADDR: %w", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	// Like with dialSCTP, the listener is a [net.TCPListener] as far as the
+	// runtime is concerned, and accepted connections are [net.TCPConn]s.
+	return net.FileListener(file)
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSCTPLoopback(t *testing.T) {
+	ln, err := Listen(TransportSCTP, "127.0.0.1:0")
+	if errors.Is(err, syscall.EPROTONOSUPPORT) || errors.Is(err, syscall.ESOCKTNOSUPPORT) || errors.Is(err, syscall.EAFNOSUPPORT) {
+		t.Skipf("SCTP not supported by the kernel: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := Dial(TransportSCTP, ln.Addr().String(), time.Second, SocketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	msg := []byte("0123456789abcdef")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(msg) {
+		t.Fatalf("expected echo %q, got %q", msg, buf)
+	}
+}
+
+func TestSCTPRejectsTCPOptions(t *testing.T) {
+	for _, opts := range []SocketOptions{
+		{Nagle: true},
+		{KeepAlive: time.Second},
+	} {
+		if err := opts.Validate(TransportSCTP); err == nil {
+			t.Errorf("%+v: expected an error for SCTP", opts)
+		}
+		if err := opts.Validate(TransportTCP); err != nil {
+			t.Errorf("%+v: unexpected error for TCP: %v", opts, err)
+		}
+		if _, err := Dial(TransportSCTP, "127.0.0.1:1", time.Second, opts); err == nil {
+			t.Errorf("%+v: expected dialing SCTP to fail", opts)
+		}
+	}
+}
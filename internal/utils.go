@@ -21,14 +21,6 @@ func ErrExit(msg string, err error) {
 	}
 }
 
-// BeNice sets the calling process' priority to the lowest possible value.
-func BeNice() error {
-	if err := syscall.Setpriority(syscall.PRIO_PROCESS, os.Getpid(), 19); err != nil {
-		return fmt.Errorf("set priority: %w", err)
-	}
-	return nil
-}
-
 // Sleep for the specified duration. No-op if d is negative or zero.
 func Sleep(d time.Duration) {
 	if d <= 0 {
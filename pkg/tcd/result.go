@@ -0,0 +1,305 @@
+package tcd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+	"github.com/cilium/test-connection-disruption/internal/server"
+)
+
+// Directions of a connection, see [Stall.Direction].
+const (
+	DirectionClientToServer = internal.DirectionClientToServer
+	DirectionServerToClient = internal.DirectionServerToClient
+	DirectionBoth           = client.DirectionBoth
+)
+
+// ClientResult summarizes a client run.
+type ClientResult struct {
+	// Total number of messages sent and received.
+	Sent, Received uint64
+	// MaxGap is the longest time the client went without receiving a message,
+	// counting from when the connection was established.
+	MaxGap time.Duration
+	// Duration is the time from establishing the connection until the client
+	// stopped.
+	Duration time.Duration
+	// Lost is the number of requests left without a reply once the client
+	// stopped, in echo mode.
+	Lost uint64
+	// Stalls is the number of gaps longer than five intervals, or twenty with
+	// Poisson arrivals, and Stalled their total duration. StallList holds the
+	// first of them.
+	Stalls    int
+	Stalled   time.Duration
+	StallList []Stall
+	// RTT summarizes round-trip times, and RTTP99 is their 99th percentile, in
+	// echo mode.
+	RTT    RTTStats
+	RTTP99 time.Duration
+	// MinOpsPerSecond is the fewest messages received in any of the Intervals
+	// full one-second intervals measured.
+	MinOpsPerSecond uint64
+	Intervals       int
+	// DialRetries is the number of times the client retried connecting.
+	DialRetries int
+	// MissedSlots is the number of send slots the client didn't meet with
+	// [WithRate], and DroppedSlots those it didn't send at all.
+	MissedSlots, DroppedSlots uint64
+	// Pacing summarizes the intervals between requests sent, PacingP99 is
+	// their 99th percentile and TargetRate the rate the client aimed for.
+	// ClientBound is the number of seconds in which the client itself kept
+	// from sending at the target rate, rather than the network.
+	Pacing      SendIntervals
+	PacingP99   time.Duration
+	TargetRate  float64
+	ClientBound int
+	// Upstream and Downstream summarize the delays from client to server and
+	// back, and ClockOffset is the estimated offset of the server's clock at
+	// the end, with [WithOneWayDelay].
+	Upstream, Downstream RTTStats
+	ClockOffset          time.Duration
+	// ClientToServer and ServerToClient break down the messages by direction,
+	// as far as the client can tell, see [Direction].
+	ClientToServer, ServerToClient Direction
+}
+
+// Stall is a gap between two received messages long enough to count, see
+// [ClientResult.Stalls].
+type Stall struct {
+	Start    time.Time
+	Duration time.Duration
+	// Direction is the direction the stall occurred in, one of
+	// [DirectionClientToServer], [DirectionServerToClient] or [DirectionBoth],
+	// with [WithOneWayDelay].
+	Direction string
+	// ICMPSent and ICMPReceived are the ICMP echo requests sent during the
+	// stall and the replies received, with [WithICMPInterval].
+	ICMPSent, ICMPReceived uint64
+}
+
+// Direction summarizes the messages sent in one direction. The client knows
+// how many it sent itself and how many it received from the server, and the
+// longest gap between them. It only learns how many of its own messages
+// arrived from the replies in echo mode, and how far apart with
+// [WithOneWayDelay]; the fields it can't tell are left zero.
+type Direction struct {
+	Sent, Received uint64
+	MaxGap         time.Duration
+}
+
+// RTTStats summarizes round-trip times or one-way delays.
+type RTTStats struct {
+	Count         uint64
+	Min, Max, Sum time.Duration
+}
+
+// Avg returns the average, or 0 without samples.
+func (s RTTStats) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// SendIntervals summarizes the intervals between requests sent.
+type SendIntervals struct {
+	Count         uint64
+	Min, Max, Sum time.Duration
+	// Jitter is the standard deviation of the intervals.
+	Jitter time.Duration
+	// Blocked is the time spent writing requests to the socket, which grows
+	// when the network doesn't keep up rather than the client.
+	Blocked time.Duration
+}
+
+// Avg returns the average interval, or 0 without samples.
+func (s SendIntervals) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Rate returns the effective number of requests sent per second, or 0
+// without samples.
+func (s SendIntervals) Rate() float64 {
+	if s.Sum <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Sum.Seconds()
+}
+
+func newRTTStats(s client.RTTStats) RTTStats {
+	return RTTStats{Count: s.Count, Min: s.Min, Max: s.Max, Sum: s.Sum}
+}
+
+func newSendIntervals(s client.SendIntervals) SendIntervals {
+	return SendIntervals{Count: s.Count, Min: s.Min, Max: s.Max, Sum: s.Sum, Jitter: s.Jitter(), Blocked: s.Blocked}
+}
+
+func newDirection(d client.DirectionResult) Direction {
+	return Direction{Sent: d.Sent, Received: d.Received, MaxGap: d.MaxGap}
+}
+
+func newClientResult(r client.Result) ClientResult {
+	res := ClientResult{
+		Sent:            r.Sent,
+		Received:        r.Received,
+		MaxGap:          r.MaxGap,
+		Duration:        r.Duration,
+		Lost:            r.Lost,
+		Stalls:          r.Stalls,
+		Stalled:         r.Stalled,
+		RTT:             newRTTStats(r.RTT),
+		RTTP99:          r.RTTP99,
+		MinOpsPerSecond: r.MinOpsPerSecond,
+		Intervals:       r.Intervals,
+		DialRetries:     r.DialRetries,
+		MissedSlots:     r.MissedSlots,
+		DroppedSlots:    r.DroppedSlots,
+		Pacing:          newSendIntervals(r.Pacing),
+		PacingP99:       r.PacingP99,
+		TargetRate:      r.TargetRate,
+		ClientBound:     r.ClientBound,
+		Upstream:        newRTTStats(r.Upstream),
+		Downstream:      newRTTStats(r.Downstream),
+		ClockOffset:     r.ClockOffset,
+		ClientToServer:  newDirection(r.ClientToServer),
+		ServerToClient:  newDirection(r.ServerToClient),
+	}
+	for _, s := range r.StallList {
+		res.StallList = append(res.StallList, Stall{
+			Start:        s.Start,
+			Duration:     s.Duration,
+			Direction:    s.Direction,
+			ICMPSent:     s.ICMPSent,
+			ICMPReceived: s.ICMPReceived,
+		})
+	}
+	return res
+}
+
+// Thresholds are pass/fail criteria of a client run on top of the timeout.
+// Zero values and nil pointers disable a threshold.
+type Thresholds struct {
+	// MaxGap is the longest acceptable time without receiving a message.
+	MaxGap time.Duration
+	// MaxStalled is the longest acceptable total time spent in stalls.
+	MaxStalled time.Duration
+	// MaxLoss is the largest acceptable fraction of requests left without a
+	// reply, in echo mode. Only evaluated when the client stops.
+	MaxLoss *float64
+	// MaxP99RTT is the longest acceptable 99th percentile round-trip time, in
+	// echo mode.
+	MaxP99RTT time.Duration
+	// MinOpsPerSecond is the fewest messages that must be received in every
+	// one-second interval.
+	MinOpsPerSecond uint64
+	// MaxDialRetries is the largest acceptable number of connection retries.
+	MaxDialRetries *int
+}
+
+func (t Thresholds) clientThresholds() client.Thresholds {
+	return client.Thresholds{
+		MaxGap:          t.MaxGap,
+		MaxStalled:      t.MaxStalled,
+		MaxLoss:         t.MaxLoss,
+		MaxP99RTT:       t.MaxP99RTT,
+		MinOpsPerSecond: t.MinOpsPerSecond,
+		MaxDialRetries:  t.MaxDialRetries,
+	}
+}
+
+// ThresholdError reports a violated threshold.
+type ThresholdError struct {
+	// Threshold is the name of the threshold, as in a scenario file.
+	Threshold string
+	// Message describes the violation.
+	Message string
+
+	err error
+}
+
+func (e *ThresholdError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ThresholdError) Unwrap() error {
+	return e.err
+}
+
+// clientError exposes a violated threshold in err as a [*ThresholdError].
+func clientError(err error) error {
+	var te *client.ThresholdError
+	if !errors.As(err, &te) {
+		return err
+	}
+	return &ThresholdError{Threshold: te.Threshold, Message: te.Message, err: err}
+}
+
+// ErrorKind categorizes client failures, see [KindOf].
+type ErrorKind int
+
+// Kinds of client failures, in order of severity.
+const (
+	// KindUnknown is any failure not covered by the other kinds, such as
+	// invalid configuration.
+	KindUnknown ErrorKind = iota
+	// KindDial is a failure to connect to the server, after all attempts.
+	KindDial
+	// KindThreshold is a violated threshold, see [Thresholds].
+	KindThreshold
+	// KindRead is a failure to read from the connection.
+	KindRead
+	// KindWrite is a failure to write to the connection, other than it being
+	// reset.
+	KindWrite
+	// KindClosed is the connection being reset, or closed by the server before
+	// the client received everything it expected.
+	KindClosed
+	// KindTimeout is no message being received within the timeout.
+	KindTimeout
+	// KindCorrupt is a message that arrived with unexpected content.
+	KindCorrupt
+)
+
+var errorKinds = map[client.Kind]ErrorKind{
+	client.KindUnknown:   KindUnknown,
+	client.KindDial:      KindDial,
+	client.KindThreshold: KindThreshold,
+	client.KindRead:      KindRead,
+	client.KindWrite:     KindWrite,
+	client.KindClosed:    KindClosed,
+	client.KindTimeout:   KindTimeout,
+	client.KindCorrupt:   KindCorrupt,
+}
+
+func (k ErrorKind) String() string {
+	for ck, kind := range errorKinds {
+		if kind == k {
+			return ck.String()
+		}
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// KindOf returns the kind of a client's error, the most severe one if it
+// combines several.
+func KindOf(err error) ErrorKind {
+	return errorKinds[client.KindOf(err)]
+}
+
+// ServerResult summarizes a server run.
+type ServerResult struct {
+	// Number of connections accepted, and the number of those that ended with
+	// an error.
+	Connections, Failed uint64
+}
+
+func newServerResult(r server.Result) ServerResult {
+	return ServerResult{Connections: r.Connections, Failed: r.Failed}
+}
@@ -0,0 +1,509 @@
+// Package tcd embeds the connection disruption test client and server in
+// other Go programs, such as end-to-end test suites, so they don't need to
+// shell out to the binaries and parse their output.
+//
+//	srv, err := tcd.NewServer(":8000")
+//	...
+//	srv.Start()
+//	defer srv.Stop()
+//
+//	c := tcd.NewClient("server:8000", tcd.WithInterval(10*time.Millisecond))
+//	c.Start()
+//	// Upgrade, restart or otherwise disrupt the datapath.
+//	res, err := c.Stop()
+package tcd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cilium/test-connection-disruption/internal"
+	"github.com/cilium/test-connection-disruption/internal/client"
+	"github.com/cilium/test-connection-disruption/internal/server"
+)
+
+// Transports, traffic modes and arrival processes, see the binaries' usage for details.
+const (
+	TransportTCP  = internal.TransportTCP
+	TransportSCTP = internal.TransportSCTP
+
+	ModeEcho    = internal.ModeEcho
+	ModeReverse = internal.ModeReverse
+	ModeDuplex  = internal.ModeDuplex
+
+	ArrivalConstant = internal.ArrivalConstant
+	ArrivalPoisson  = internal.ArrivalPoisson
+)
+
+// Stats holds a client's counters of a one second interval.
+type Stats struct {
+	// Number of messages sent and received.
+	TX, RX uint64
+	// Number of bytes received.
+	Bytes uint64
+	// Number of ICMP echo requests sent and replies received.
+	ICMPTX, ICMPRX uint64
+	// Phase is the phase of the test at the end of the interval, if tracked.
+	Phase string
+	// InFlight is the number of requests without a reply at the end of the
+	// interval, in echo mode.
+	InFlight uint64
+	// RTT summarizes the round-trip times of the interval, and RTTBuckets
+	// holds their distribution, in echo mode.
+	RTT        RTTStats
+	RTTBuckets []Bucket
+	// Stalled is set if a stall ended during the interval, or is ongoing at
+	// its end.
+	Stalled bool
+	// MissedSlots is the number of send slots missed with [WithRate].
+	MissedSlots uint64
+	// Pacing summarizes the intervals between requests sent, and TargetRate
+	// is the number of requests per second the client aims for, in echo and
+	// duplex mode.
+	Pacing     SendIntervals
+	TargetRate float64
+	// Upstream and Downstream summarize the delays from client to server and
+	// back, and ClockOffset is the estimated offset of the server's clock,
+	// with [WithOneWayDelay].
+	Upstream, Downstream RTTStats
+	ClockOffset          time.Duration
+}
+
+// Bucket is a bucket of a distribution of durations.
+type Bucket struct {
+	// Upper is the largest duration in the bucket.
+	Upper time.Duration
+	Count uint64
+}
+
+func newStats(st client.Stats) Stats {
+	buckets := make([]Bucket, len(st.RTTBuckets))
+	for i, b := range st.RTTBuckets {
+		buckets[i] = Bucket{Upper: b.Upper, Count: b.Count}
+	}
+	return Stats{
+		TX:          st.TX,
+		RX:          st.RX,
+		Bytes:       st.Bytes,
+		ICMPTX:      st.ICMPTX,
+		ICMPRX:      st.ICMPRX,
+		Phase:       st.Phase,
+		InFlight:    st.InFlight,
+		RTT:         newRTTStats(st.RTT),
+		RTTBuckets:  buckets,
+		Stalled:     st.Stalled,
+		MissedSlots: st.MissedSlots,
+		Pacing:      newSendIntervals(st.Pacing),
+		TargetRate:  st.TargetRate,
+		Upstream:    newRTTStats(st.Upstream),
+		Downstream:  newRTTStats(st.Downstream),
+		ClockOffset: st.ClockOffset,
+	}
+}
+
+// ErrStarted is returned when starting or running a client or server that was
+// started or run before. Each of them runs once.
+var ErrStarted = errors.New("already started")
+
+// ErrNotStamped is returned by a client with [WithOneWayDelay] when the
+// server doesn't stamp its replies. It is of [KindUnknown].
+var ErrNotStamped = client.ErrNotStamped
+
+// ErrStamped is returned by a client without [WithOneWayDelay] when the
+// server stamps its replies. It is of [KindUnknown].
+var ErrStamped = client.ErrStamped
+
+type options struct {
+	transport      string
+	mode           string
+	interval       time.Duration
+	rate           float64
+	arrival        string
+	timeout        time.Duration
+	halfClose      bool
+	proxyProtocol  int
+	oneWayDelay    bool
+	icmpInterval   time.Duration
+	dialAttempts   int
+	connectTimeout time.Duration
+	log            *slog.Logger
+	onReady        func()
+	onStats        func(Stats)
+	thresholds     Thresholds
+}
+
+// Option configures a Client or Server. Options that only apply to one of them
+// are ignored by the other.
+type Option func(*options)
+
+func newOptions(opts []Option) options {
+	o := options{
+		transport:    TransportTCP,
+		mode:         ModeEcho,
+		interval:     50 * time.Millisecond,
+		timeout:      5 * time.Second,
+		dialAttempts: 1,
+		log:          internal.DiscardLogger(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithTransport sets the transport protocol. Defaults to TCP.
+func WithTransport(transport string) Option {
+	return func(o *options) { o.transport = transport }
+}
+
+// WithMode sets the traffic mode, which must be the same on client and server.
+// Defaults to echo.
+func WithMode(mode string) Option {
+	return func(o *options) { o.mode = mode }
+}
+
+// WithInterval sets the interval between two messages. Defaults to 50ms.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) { o.interval = d }
+}
+
+// WithRate makes the client send rate messages per second on an absolute
+// timeline, with arrival being ArrivalConstant or ArrivalPoisson, and measure
+// round-trip times from when a message was due.
+func WithRate(rate float64, arrival string) Option {
+	return func(o *options) { o.rate, o.arrival = rate, arrival }
+}
+
+// WithTimeout sets the longest time to wait for a message before failing.
+// Defaults to 5s.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithHalfClose enables half-closing connections on shutdown and verifying
+// all messages arrived.
+func WithHalfClose() Option {
+	return func(o *options) { o.halfClose = true }
+}
+
+// WithProxyProtocol makes the client send a PROXY protocol header of the given
+// version, and the server expect one.
+func WithProxyProtocol(version int) Option {
+	return func(o *options) { o.proxyProtocol = version }
+}
+
+// WithOneWayDelay makes the server stamp its replies in echo mode, and the
+// client measure the delay in each direction from them.
+func WithOneWayDelay() Option {
+	return func(o *options) { o.oneWayDelay = true }
+}
+
+// WithICMPInterval makes the client send ICMP echo requests to the server's
+// host at the given interval alongside the flow.
+func WithICMPInterval(d time.Duration) Option {
+	return func(o *options) { o.icmpInterval = d }
+}
+
+// WithDialAttempts sets the number of connection attempts of the client, one
+// second apart. Defaults to 1.
+func WithDialAttempts(n int) Option {
+	return func(o *options) { o.dialAttempts = n }
+}
+
+// WithConnectTimeout bounds every connection attempt of the client. Defaults
+// to waiting for the OS to give up.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(o *options) { o.connectTimeout = d }
+}
+
+// WithOutput sets the writers for progress messages and for errors on
+// individual server connections, logged as text. Output is discarded by
+// default.
+func WithOutput(out, err io.Writer) Option {
+	return func(o *options) {
+		o.log = slog.New(splitHandler{
+			out: slog.NewTextHandler(out, nil),
+			err: slog.NewTextHandler(err, nil),
+		})
+	}
+}
+
+// WithLogger sets the logger for progress messages and errors, as an
+// alternative to [WithOutput]. Output is discarded by default.
+func WithLogger(log *slog.Logger) Option {
+	return func(o *options) { o.log = log }
+}
+
+// splitHandler passes errors to one handler and all other messages to another.
+type splitHandler struct {
+	out, err slog.Handler
+}
+
+func (h splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return h.err.Enabled(ctx, level)
+	}
+	return h.out.Enabled(ctx, level)
+}
+
+func (h splitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		return h.err.Handle(ctx, r)
+	}
+	return h.out.Handle(ctx, r)
+}
+
+func (h splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return splitHandler{out: h.out.WithAttrs(attrs), err: h.err.WithAttrs(attrs)}
+}
+
+func (h splitHandler) WithGroup(name string) slog.Handler {
+	return splitHandler{out: h.out.WithGroup(name), err: h.err.WithGroup(name)}
+}
+
+// WithOnReady sets a callback run once the client's traffic is flowing, or
+// once the server is accepting connections.
+func WithOnReady(f func()) Option {
+	return func(o *options) { o.onReady = f }
+}
+
+// WithOnStats sets a callback run with the client's counters every second.
+func WithOnStats(f func(Stats)) Option {
+	return func(o *options) { o.onStats = f }
+}
+
+// WithThresholds makes the client fail with a [*ThresholdError] when its run
+// violates one of the thresholds.
+func WithThresholds(t Thresholds) Option {
+	return func(o *options) { o.thresholds = t }
+}
+
+// errNotStarted is returned when stopping a run that wasn't started.
+var errNotStarted = errors.New("not started")
+
+// run runs f in the background until stopped, or guards a client or server
+// run in the foreground.
+type run struct {
+	mu      sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	// done is closed once a started run finished, and err holds its error.
+	done chan struct{}
+	err  error
+}
+
+func newRun() *run {
+	return &run{done: make(chan struct{})}
+}
+
+// claim marks the run as started, or returns ErrStarted if it was before.
+func (r *run) claim() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return ErrStarted
+	}
+	r.started = true
+	return nil
+}
+
+func (r *run) start(f func(context.Context) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return ErrStarted
+	}
+	r.started = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		defer close(r.done)
+		r.err = f(ctx)
+	}()
+	return nil
+}
+
+// stop cancels a started run and returns its error once it finished, or
+// errNotStarted.
+func (r *run) stop() error {
+	r.mu.Lock()
+	cancel := r.cancel
+	r.mu.Unlock()
+	if cancel == nil {
+		return errNotStarted
+	}
+	cancel()
+	<-r.done
+	return r.err
+}
+
+// Client is an embedded connection disruption test client.
+type Client struct {
+	c   *client.Client
+	run *run
+}
+
+// NewClient returns a client for the server at addr.
+func NewClient(addr string, opts ...Option) *Client {
+	o := newOptions(opts)
+	var onStats func(client.Stats)
+	if o.onStats != nil {
+		onStats = func(st client.Stats) { o.onStats(newStats(st)) }
+	}
+	return &Client{run: newRun(), c: client.New(client.Config{
+		Addr:           addr,
+		Transport:      o.transport,
+		Mode:           o.mode,
+		Interval:       o.interval,
+		Rate:           o.rate,
+		Arrival:        o.arrival,
+		Timeout:        o.timeout,
+		HalfClose:      o.halfClose,
+		ProxyProtocol:  o.proxyProtocol,
+		OneWayDelay:    o.oneWayDelay,
+		ICMPInterval:   o.icmpInterval,
+		DialAttempts:   o.dialAttempts,
+		ConnectTimeout: o.connectTimeout,
+		Log:            o.log,
+		OnReady:        o.onReady,
+		OnStats:        onStats,
+		Thresholds:     o.thresholds.clientThresholds(),
+	})}
+}
+
+// Run runs the client until ctx is cancelled or the flow is disrupted. Returns
+// ErrStarted if the client was run or started before.
+func (c *Client) Run(ctx context.Context) (ClientResult, error) {
+	if err := c.run.claim(); err != nil {
+		return ClientResult{}, err
+	}
+	err := c.c.Run(ctx)
+	return c.Result(), clientError(err)
+}
+
+// Start runs the client in the background until Stop is called. Use Done to
+// learn about disruptions before that. Returns ErrStarted if the client was
+// run or started before.
+func (c *Client) Start() error {
+	return c.run.start(func(ctx context.Context) error {
+		return clientError(c.c.Run(ctx))
+	})
+}
+
+// Done returns a channel that is closed once a started client stopped, either
+// because of Stop or because the flow was disrupted.
+func (c *Client) Done() <-chan struct{} {
+	return c.run.done
+}
+
+// Stop shuts down a started client gracefully and returns its result, along
+// with the error that ended the run, if any.
+func (c *Client) Stop() (ClientResult, error) {
+	err := c.run.stop()
+	if errors.Is(err, errNotStarted) {
+		err = nil
+	}
+	return c.Result(), err
+}
+
+// Result returns the client's result so far.
+func (c *Client) Result() ClientResult {
+	return newClientResult(c.c.Result())
+}
+
+// Server is an embedded connection disruption test server.
+type Server struct {
+	s       *server.Server
+	onReady func()
+	run     *run
+}
+
+// NewServer returns a server listening on addr.
+func NewServer(addr string, opts ...Option) (*Server, error) {
+	o := newOptions(opts)
+	s, err := server.Listen(server.Config{
+		Transport:     o.transport,
+		Mode:          o.mode,
+		Interval:      o.interval,
+		Timeout:       o.timeout,
+		HalfClose:     o.halfClose,
+		ProxyProtocol: o.proxyProtocol != 0,
+		Stamp:         o.oneWayDelay,
+		Log:           o.log,
+	}, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{s: s, onReady: o.onReady, run: newRun()}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.s.Addr().String()
+}
+
+// ServerConn describes a connection open on a server.
+type ServerConn struct {
+	// Local and Remote are the addresses of the connection. With the PROXY
+	// protocol, Remote is the original client address conveyed in the header.
+	Local, Remote string
+	// Proxy is the address the connection was accepted from with the PROXY
+	// protocol, and empty otherwise.
+	Proxy string
+	// Accepted is when the connection was accepted.
+	Accepted time.Time
+}
+
+// Conns returns the connections open on the server, oldest first.
+func (s *Server) Conns() []ServerConn {
+	var conns []ServerConn
+	for _, c := range s.s.Conns() {
+		conn := ServerConn{Local: c.Local.String(), Remote: c.Remote.String(), Accepted: c.Accepted}
+		if c.Proxy != nil {
+			conn.Proxy = c.Proxy.String()
+		}
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Run serves connections until ctx is cancelled. Returns ErrStarted if the
+// server was run or started before.
+func (s *Server) Run(ctx context.Context) (ServerResult, error) {
+	if err := s.run.claim(); err != nil {
+		return ServerResult{}, err
+	}
+	return s.serve(ctx)
+}
+
+// Start serves connections in the background until Stop is called. Returns
+// ErrStarted if the server was run or started before.
+func (s *Server) Start() error {
+	return s.run.start(func(ctx context.Context) error {
+		_, err := s.serve(ctx)
+		return err
+	})
+}
+
+func (s *Server) serve(ctx context.Context) (ServerResult, error) {
+	if s.onReady != nil {
+		s.onReady()
+	}
+	err := s.s.Serve(ctx)
+	return newServerResult(s.s.Result()), err
+}
+
+// Stop closes the listener and all connections of the server, and returns its
+// result.
+func (s *Server) Stop() (ServerResult, error) {
+	err := s.run.stop()
+	if errors.Is(err, errNotStarted) {
+		err = s.s.Close()
+	}
+	return newServerResult(s.s.Result()), err
+}
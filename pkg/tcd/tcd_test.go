@@ -0,0 +1,211 @@
+package tcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientServer(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0", WithInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); !errors.Is(err, ErrStarted) {
+		t.Fatalf("expected %v, got %v", ErrStarted, err)
+	}
+
+	var ready atomic.Bool
+	var stats, buckets atomic.Uint64
+	c := NewClient(srv.Addr(),
+		WithInterval(10*time.Millisecond),
+		WithTimeout(time.Second),
+		WithHalfClose(),
+		WithOnReady(func() { ready.Store(true) }),
+		WithOnStats(func(s Stats) {
+			stats.Add(s.RX)
+			buckets.Add(uint64(len(s.RTTBuckets)))
+		}),
+	)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	res, err := c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ready.Load() {
+		t.Error("ready callback not called")
+	}
+	if stats.Load() == 0 || buckets.Load() == 0 {
+		t.Error("stats callback not called with round-trip times")
+	}
+	if res.Sent == 0 || res.Sent != res.Received {
+		t.Errorf("expected all messages to be received, sent %d, received %d", res.Sent, res.Received)
+	}
+	if res.Duration < time.Second || res.MaxGap <= 0 || res.MaxGap >= time.Second {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	sres, err := srv.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sres.Connections != 1 || sres.Failed != 0 {
+		t.Errorf("unexpected server result %+v", sres)
+	}
+}
+
+func TestClientStart(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	c := NewClient(srv.Addr(), WithTimeout(300*time.Millisecond))
+	done := c.Done()
+	if done == nil {
+		t.Fatal("expected a channel before starting")
+	}
+	if _, err := c.Stop(); err != nil {
+		t.Fatal("stopping before start:", err)
+	}
+
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := c.Start(); {
+			case err == nil:
+				started.Add(1)
+			case !errors.Is(err, ErrStarted):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := started.Load(); n != 1 {
+		t.Fatalf("expected one start to succeed, got %d", n)
+	}
+
+	// The server never serves the connection, so the client times out.
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel returned before starting not closed")
+	}
+	c.Stop()
+}
+
+func TestClientTimeout(t *testing.T) {
+	// Don't start the server, so connections are established by the kernel but
+	// never served.
+	srv, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	c := NewClient(srv.Addr(), WithTimeout(300*time.Millisecond))
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't notice the stalled flow")
+	}
+
+	res, err := c.Stop()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if res.MaxGap < 300*time.Millisecond {
+		t.Fatalf("expected gap of at least the timeout, got %s", res.MaxGap)
+	}
+}
+
+func TestServerConns(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0", WithProxyProtocol(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "PROXY TCP4 192.0.2.1 127.0.0.1 1234 8000\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []ServerConn
+	for deadline := time.Now().Add(5 * time.Second); len(conns) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		conns = srv.Conns()
+	}
+	if len(conns) != 1 {
+		t.Fatalf("expected one connection, got %+v", conns)
+	}
+	if c := conns[0]; c.Remote != "192.0.2.1:1234" || c.Proxy != conn.LocalAddr().String() || c.Local != srv.Addr() {
+		t.Errorf("unexpected connection %+v", c)
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); len(conns) != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		conns = srv.Conns()
+	}
+	if len(conns) != 0 {
+		t.Errorf("expected closed connection to be removed, got %+v", conns)
+	}
+}
+
+func TestClientRunOnce(t *testing.T) {
+	srv, err := NewServer("127.0.0.1:0", WithInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+	if _, err := srv.Run(context.Background()); !errors.Is(err, ErrStarted) {
+		t.Fatalf("expected %v running a started server, got %v", ErrStarted, err)
+	}
+
+	// Violate a threshold to see it exposed.
+	c := NewClient(srv.Addr(), WithInterval(10*time.Millisecond), WithThresholds(Thresholds{MinOpsPerSecond: 1000}))
+	_, err = c.Run(context.Background())
+	var te *ThresholdError
+	if !errors.As(err, &te) || te.Threshold != "min_ops_per_second" || KindOf(err) != KindThreshold {
+		t.Fatalf("expected the min_ops_per_second threshold to be violated, got %v", err)
+	}
+
+	if _, err := c.Run(context.Background()); !errors.Is(err, ErrStarted) {
+		t.Fatalf("expected %v running a client twice, got %v", ErrStarted, err)
+	}
+	if err := c.Start(); !errors.Is(err, ErrStarted) {
+		t.Fatalf("expected %v starting a client that ran, got %v", ErrStarted, err)
+	}
+}